@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Splits address like 'tcp://127.0.0.1:7890' or 'unix:///tmp/gosemki.sock'
+// into network and address accepted by net.Listen() and net.Dial().
+// Address without scheme is treated as unix socket path.
+func ParseAddress(addr string) (network, address string, err error) {
+	index := strings.Index(addr, "://")
+	if index < 0 {
+		return "unix", addr, nil
+	}
+	network = addr[:index]
+	address = addr[index+len("://"):]
+	switch network {
+	case "unix", "tcp", "tcp4", "tcp6":
+	default:
+		return "", "", errors.New(fmt.Sprintf("unsupported network '%s' in address '%s'", network, addr))
+	}
+	if len(address) == 0 {
+		return "", "", errors.New(fmt.Sprintf("missed address after '%s://'", network))
+	}
+	return network, address, nil
+}
+
+func FormatAddress(network, address string) string {
+	return network + "://" + address
+}
+
+// TCP listeners are reachable from other users and machines,
+// so connections to them must pass token authentication.
+func IsTokenRequired(network string) bool {
+	return strings.HasPrefix(network, "tcp")
+}
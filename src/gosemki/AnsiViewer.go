@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	AnsiColors256       = "256"
+	AnsiColorsTruecolor = "truecolor"
+	ANSI_RESET          = "\x1b[0m"
+)
+
+// Theme maps the same class names as HTML export (k-fun, t-kw, err...)
+// to styles like "bold #4078f2", attributes are bold, italic and underline
+var g_defaultAnsiTheme = map[string]string{
+	"t-kw":  "bold #c678dd",
+	"t-str": "#98c379",
+	"t-num": "#d19a66",
+	"t-com": "italic #7f848e",
+	"k-pkg": "#56b6c2",
+	"k-con": "#d19a66",
+	"k-typ": "#e5c07b",
+	"k-fld": "#e06c75",
+	"k-fun": "#61afef",
+	"k-lbl": "#c678dd",
+	"err":   "underline #ff5f5f",
+}
+
+type AnsiTheme struct {
+	Colors string            // AnsiColors256 or AnsiColorsTruecolor
+	Styles map[string]string // SGR escape sequences by class name
+}
+
+// Prints file with ANSI colors, errors are underlined and annotated below
+// their lines
+func (this *Client) ExecCat() int {
+	flagSet := flag.NewFlagSet("cat", flag.ExitOnError)
+	themePath := flagSet.String("theme", "", "JSON file mapping classes like 'k-fun' to styles like 'bold #61afef'")
+	colors := flagSet.String("colors", GetDefaultAnsiColors(), "color mode: 256 or truecolor")
+	lineNumbers := flagSet.Bool("n", false, "print line numbers")
+	this.CommandArgs = ParseCommandFlags(flagSet, this.CommandArgs)
+	content, path := this.ReadSourceFile()
+
+	themeStyles := g_defaultAnsiTheme
+	if len(*themePath) != 0 {
+		themeStyles = make(map[string]string)
+		data, err := ioutil.ReadFile(*themePath)
+		if err != nil {
+			panic(err)
+		}
+		if err = json.Unmarshal(data, &themeStyles); err != nil {
+			panic(errors.New("malformed theme '" + *themePath + "': " + err.Error()))
+		}
+	}
+	theme, err := NewAnsiTheme(themeStyles, *colors)
+	if err != nil {
+		panic(err)
+	}
+	context := PackGoBuildContext(&build.Default)
+	result := ClientReindex(this.RpcClient, content, path, context, IndexerOptions{})
+	writer := bufio.NewWriter(os.Stdout)
+	WriteAnsiSource(writer, content, &result, theme, *lineNumbers)
+	writer.Flush()
+	return 0
+}
+
+func GetDefaultAnsiColors() string {
+	if colorTerm := os.Getenv("COLORTERM"); colorTerm == "truecolor" || colorTerm == "24bit" {
+		return AnsiColorsTruecolor
+	}
+	return AnsiColors256
+}
+
+func NewAnsiTheme(styles map[string]string, colors string) (*AnsiTheme, error) {
+	if colors != AnsiColors256 && colors != AnsiColorsTruecolor {
+		return nil, errors.New("unknown color mode '" + colors + "', expected 256 or truecolor")
+	}
+	theme := &AnsiTheme{Colors: colors, Styles: make(map[string]string)}
+	for class, style := range styles {
+		sequence, err := theme.ParseStyle(style)
+		if err != nil {
+			return nil, errors.New("style of '" + class + "': " + err.Error())
+		}
+		theme.Styles[class] = sequence
+	}
+	return theme, nil
+}
+
+// Converts style like "bold #61afef" into SGR escape sequence
+func (this *AnsiTheme) ParseStyle(style string) (string, error) {
+	var params []string
+	for _, word := range strings.Fields(style) {
+		switch {
+		case word == "bold":
+			params = append(params, "1")
+		case word == "italic":
+			params = append(params, "3")
+		case word == "underline":
+			params = append(params, "4")
+		case strings.HasPrefix(word, "#") && len(word) == 7:
+			rgb, err := strconv.ParseUint(word[1:], 16, 32)
+			if err != nil {
+				return "", errors.New("invalid color '" + word + "'")
+			}
+			r, g, b := int(rgb>>16), int(rgb>>8&0xff), int(rgb&0xff)
+			if this.Colors == AnsiColorsTruecolor {
+				params = append(params, fmt.Sprintf("38;2;%d;%d;%d", r, g, b))
+			} else {
+				params = append(params, fmt.Sprintf("38;5;%d", GetXterm256Color(r, g, b)))
+			}
+		default:
+			return "", errors.New("unknown attribute '" + word + "'")
+		}
+	}
+	if len(params) == 0 {
+		return "", nil
+	}
+	return "\x1b[" + strings.Join(params, ";") + "m", nil
+}
+
+// Nearest color of xterm 6x6x6 color cube
+func GetXterm256Color(r, g, b int) int {
+	toCube := func(value int) int {
+		return (value*5 + 127) / 255
+	}
+	return 16 + 36*toCube(r) + 6*toCube(g) + toCube(b)
+}
+
+// Sequence for segment, error style is applied over identifier style
+func (this *AnsiTheme) GetSegmentStyle(segment *HighlightSegment) string {
+	style := ""
+	if segment.Kind != GoKindBad {
+		style = this.Styles["k-"+goKindToString(segment.Kind)]
+	} else if class, ok := g_tokenClassCss[segment.TokenClass]; ok {
+		style = this.Styles[class]
+	}
+	if len(segment.Errors) != 0 {
+		style += this.Styles["err"]
+	}
+	return style
+}
+
+func WriteAnsiSource(writer io.Writer, content []byte, result *IndexerResult, theme *AnsiTheme, lineNumbers bool) {
+	segments := BuildHighlightSegments(content, result)
+	lineCount := strings.Count(string(content), "\n") + 1
+	gutterWidth := len(strconv.Itoa(lineCount))
+	line := 1
+	lineStart := 0
+	writeGutter := func() {
+		if lineNumbers {
+			fmt.Fprintf(writer, "%*d  ", gutterWidth, line)
+		}
+	}
+	writeGutter()
+	for _, segment := range segments {
+		style := theme.GetSegmentStyle(&segment)
+		text := string(content[segment.Start:segment.End])
+		offset := segment.Start
+		for {
+			lineEnd := strings.IndexByte(text, '\n')
+			part := text
+			if lineEnd >= 0 {
+				part = text[:lineEnd]
+			}
+			if len(style) != 0 && len(part) != 0 {
+				io.WriteString(writer, style+part+ANSI_RESET)
+			} else {
+				io.WriteString(writer, part)
+			}
+			if lineEnd < 0 {
+				break
+			}
+			io.WriteString(writer, "\n")
+			offset += lineEnd + 1
+			WriteAnsiAnnotations(writer, content, result, theme, lineStart, offset-1, lineNumbers, gutterWidth)
+			text = text[lineEnd+1:]
+			line++
+			lineStart = offset
+			if lineStart < len(content) {
+				writeGutter()
+			}
+		}
+	}
+	if lineStart < len(content) {
+		io.WriteString(writer, "\n")
+		WriteAnsiAnnotations(writer, content, result, theme, lineStart, len(content), lineNumbers, gutterWidth)
+	} else {
+		// content is empty or ends with line break, errors at end of file
+		// are annotated after the last line
+		if len(content) == 0 {
+			io.WriteString(writer, "\n")
+		}
+		WriteAnsiAnnotations(writer, content, result, theme, lineStart, len(content), lineNumbers, gutterWidth)
+	}
+}
+
+// Writes carets under errors started in line [lineStart, lineEnd) with
+// error messages, whitespace before carets repeats tabs of the line
+func WriteAnsiAnnotations(writer io.Writer, content []byte, result *IndexerResult, theme *AnsiTheme, lineStart, lineEnd int, lineNumbers bool, gutterWidth int) {
+	for _, goError := range result.Errors {
+		if goError.Offset < lineStart || goError.Offset > lineEnd {
+			continue
+		}
+		var indent strings.Builder
+		if lineNumbers {
+			indent.WriteString(strings.Repeat(" ", gutterWidth+2))
+		}
+		for _, r := range string(content[lineStart:goError.Offset]) {
+			if r == '\t' {
+				indent.WriteRune('\t')
+			} else {
+				indent.WriteRune(' ')
+			}
+		}
+		end := goError.End.Offset
+		if end > lineEnd {
+			end = lineEnd
+		}
+		carets := utf8.RuneCount(content[goError.Offset:ClampOffset(end, len(content))])
+		if carets == 0 {
+			carets = 1
+		}
+		message := GetSingleLineMessage(goError.Message)
+		fmt.Fprintf(writer, "%s%s%s %s%s\n", indent.String(), theme.Styles["err"], strings.Repeat("^", carets), message, ANSI_RESET)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Errors at end of file must be annotated after the last line even when
+// content ends with line break
+func TestAnsiSourceAnnotatesErrorsAtEndOfFile(t *testing.T) {
+	theme, err := NewAnsiTheme(g_defaultAnsiTheme, AnsiColors256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"package eof\n\nfunc f() {\n", "package eof\n\nfunc f() {", ""} {
+		result := IndexTestFile(t, content)
+		var output strings.Builder
+		WriteAnsiSource(&output, []byte(content), &result, theme, true)
+		if !strings.Contains(output.String(), "^ expected") {
+			t.Errorf("%q: expected annotation of error at end of file, got %q", content, output.String())
+		}
+	}
+}
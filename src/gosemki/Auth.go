@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	TOKEN_HANDSHAKE_PREFIX  = "gosemki-token "
+	TOKEN_HANDSHAKE_MAX_LEN = 1024
+	TOKEN_HANDSHAKE_TIMEOUT = 5 * time.Second
+	TOKEN_RANDOM_BYTES      = 32
+)
+
+// Reads shared secret from token file, surrounding whitespace ignored
+func ReadTokenFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(content))
+	if len(token) == 0 {
+		return "", errors.New(fmt.Sprintf("token file '%s' is empty", path))
+	}
+	return token, nil
+}
+
+// Reads token file or creates it with random secret if file does not exist.
+// File is created readable only by owner, so anybody who can read it
+// (e.g. container with mounted volume) is trusted.
+func ReadOrCreateTokenFile(path string) (string, error) {
+	if FileExists(path) {
+		return ReadTokenFile(path)
+	}
+	secret := make([]byte, TOKEN_RANDOM_BYTES)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(secret)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Client side of handshake: sends token line before any RPC traffic
+func SendAuthToken(conn net.Conn, token string) error {
+	_, err := conn.Write([]byte(TOKEN_HANDSHAKE_PREFIX + token + "\n"))
+	return err
+}
+
+// Server side of handshake: reads token line and compares it with expected.
+// Reads byte by byte to leave following RPC traffic in the connection.
+func CheckAuthToken(conn net.Conn, token string) error {
+	conn.SetReadDeadline(time.Now().Add(TOKEN_HANDSHAKE_TIMEOUT))
+	defer conn.SetReadDeadline(time.Time{})
+
+	line := make([]byte, 0, len(TOKEN_HANDSHAKE_PREFIX)+len(token)+1)
+	buffer := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buffer); err != nil {
+			return errors.New("failed to read auth token: " + err.Error())
+		}
+		if buffer[0] == '\n' {
+			break
+		}
+		if len(line) >= TOKEN_HANDSHAKE_MAX_LEN {
+			return errors.New("auth token line is too long")
+		}
+		line = append(line, buffer[0])
+	}
+	expected := []byte(TOKEN_HANDSHAKE_PREFIX + token)
+	if subtle.ConstantTimeCompare(line, expected) != 1 {
+		return errors.New("invalid auth token")
+	}
+	return nil
+}
@@ -41,16 +41,6 @@ func (this *CallExprVisitor) ApplyIdent() {
 		ast.Inspect(v, this.indexer.InspectNode)
 	}
 	if this.funName != nil {
-		pos := this.indexer.fset.Position(this.funName.NamePos)
-		goRange := GoRange{
-			GoPos: GoPos{
-				Line:   pos.Line,
-				Column: pos.Column,
-				Offset: pos.Offset,
-			},
-			Length: len(this.funName.Name),
-			Kind:   GoKindFunc,
-		}
-		this.indexer.result.AddRange(goRange)
+		this.indexer.result.AddRange(this.indexer.MakeRange(this.funName, GoKindFunc))
 	}
 }
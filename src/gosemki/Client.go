@@ -5,21 +5,28 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"go/build"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/rpc"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 type Client struct {
-	Input       string
-	Command     string
-	CommandArgs []string
-	Socket      string
-	RpcClient   *rpc.Client
+	Input        string
+	Command      string
+	CommandArgs  []string
+	Network      string
+	Address      string
+	TokenFile    string
+	CanRunServer bool
+	RpcClient    *rpc.Client
 }
 
 func (this *Client) Exec() int {
@@ -29,26 +36,27 @@ func (this *Client) Exec() int {
 			os.Exit(1)
 		}
 	}()
-	var err error
-	this.RpcClient, err = rpc.Dial("unix", this.Socket)
-	if err != nil {
-		if this.Command == "close" {
+	// commands which don't need daemon or can work without it
+	switch this.Command {
+	case "crashes":
+		return this.ExecCrashes()
+	case "lsp":
+		return this.ExecLsp()
+	case "schema":
+		return this.ExecSchema()
+	case "errors":
+		// connects itself, since failure has own exit code
+		return this.ExecErrors()
+	}
+	if this.Command == "close" {
+		var err error
+		if this.RpcClient, err = this.Dial(); err != nil {
 			fmt.Printf("Daemon not running, nothing to close\n")
 			return 0
 		}
-		if FileExists(this.Socket) {
-			os.Remove(this.Socket)
-		}
-		err = this.TryRunServer()
-		if err != nil {
-			fmt.Printf("%s\n", err.Error())
-			return 1
-		}
-		err = this.TryConnectServer("unix", this.Socket)
-		if err != nil {
-			fmt.Printf("%s\n", err.Error())
-			return 1
-		}
+	} else if err := this.Connect(); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
 	}
 	if this.RpcClient != nil {
 		defer this.RpcClient.Close()
@@ -60,6 +68,26 @@ func (this *Client) Exec() int {
 		this.ExecClose()
 	case "status":
 		this.ExecStatus()
+	case "workspace":
+		return this.ExecWorkspace()
+	case "session":
+		return this.ExecSession()
+	case "batch":
+		this.ExecBatch()
+	case "html":
+		return this.ExecHtml()
+	case "cat":
+		return this.ExecCat()
+	case "outline":
+		return this.ExecOutline()
+	case "definition":
+		return this.ExecDefinition()
+	case "references":
+		return this.ExecReferences()
+	case "hover":
+		return this.ExecHover()
+	case "complete":
+		return this.ExecComplete()
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command %s\n", this.Command)
 		return 1
@@ -67,9 +95,34 @@ func (this *Client) Exec() int {
 	return 0
 }
 
+// Connects daemon, starts it on demand if possible
+func (this *Client) Connect() error {
+	var err error
+	this.RpcClient, err = this.Dial()
+	if err == nil {
+		return nil
+	}
+	if !this.CanRunServer || this.Network != "unix" {
+		return errors.New("failed to connect daemon: " + err.Error())
+	}
+	if err = this.TryRunServer(); err != nil {
+		return err
+	}
+	return this.TryConnectServer()
+}
+
+// Checks if RPC failed because connection to daemon was lost
+func IsConnectionError(err error) bool {
+	if err == rpc.ErrShutdown || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, isNetError := err.(net.Error)
+	return isNetError
+}
+
 func (this *Client) TryRunServer() error {
 	path := GetExecutableFilename()
-	args := []string{os.Args[0], "-s"}
+	args := []string{os.Args[0], "-s", "-listen=" + FormatAddress(this.Network, this.Address)}
 	cwd, _ := os.Getwd()
 	stdin, err := os.Open(os.DevNull)
 	if err != nil {
@@ -93,10 +146,36 @@ func (this *Client) TryRunServer() error {
 	return process.Release()
 }
 
-func (this *Client) TryConnectServer(network, address string) (err error) {
+// Connects daemon and passes token handshake if required
+func (this *Client) Dial() (*rpc.Client, error) {
+	var token string
+	if IsTokenRequired(this.Network) {
+		if len(this.TokenFile) == 0 {
+			return nil, errors.New("connecting TCP address requires -token=<path> option")
+		}
+		var err error
+		token, err = ReadTokenFile(this.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	conn, err := net.Dial(this.Network, this.Address)
+	if err != nil {
+		return nil, err
+	}
+	if IsTokenRequired(this.Network) {
+		if err = SendAuthToken(conn, token); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rpc.NewClient(conn), nil
+}
+
+func (this *Client) TryConnectServer() (err error) {
 	t := 0
 	for {
-		this.RpcClient, err = rpc.Dial(network, address)
+		this.RpcClient, err = this.Dial()
 		if err != nil && t < 1000 {
 			time.Sleep(10 * time.Millisecond)
 			t += 10
@@ -108,9 +187,65 @@ func (this *Client) TryConnectServer(network, address string) (err error) {
 }
 
 func (this *Client) ExecHighlight() {
+	flagSet := flag.NewFlagSet("highlight", flag.ExitOnError)
+	fromOffset := flagSet.Int("from", -1, "index only nodes after this byte offset")
+	toOffset := flagSet.Int("to", -1, "index only nodes before this byte offset")
+	stream := flagSet.Bool("stream", false, "print NDJSON chunk after each indexing phase")
+	sections := flagSet.String("sections", "", "comma-separated sections to fetch: ranges,outline,errors,folds")
+	encoding := flagSet.String("encoding", EncodingBytes, "units of columns and lengths: bytes, utf-16 or runes")
+	this.CommandArgs = ParseCommandFlags(flagSet, this.CommandArgs)
+	var options IndexerOptions
+	options.Sections = ParseSectionsFlag(*sections)
+	options.Encoding = ParseEncodingFlag(*encoding)
+	if *fromOffset >= 0 || *toOffset >= 0 {
+		options.Viewport = &GoViewport{FromOffset: *fromOffset, ToOffset: *toOffset}
+		if *toOffset < 0 {
+			options.Viewport.ToOffset = int(^uint(0) >> 1)
+		}
+	}
 	context := PackGoBuildContext(&build.Default)
 	content, path := this.PrepareFileTraits()
-	results := ClientReindex(this.RpcClient, content, path, context)
+	if *stream {
+		ClientReindexStream(this.RpcClient, content, path, context, options, func(chunk *GoStreamChunk) {
+			jsonBytes, err := json.Marshal(chunk)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("%s\n", string(jsonBytes))
+		})
+		return
+	}
+	results := ClientReindex(this.RpcClient, content, path, context, options)
+	jsonBytes, err := json.Marshal(results)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%s\n", string(jsonBytes))
+}
+
+// Highlights several files in one request, content is read from disk
+func (this *Client) ExecBatch() {
+	flagSet := flag.NewFlagSet("batch", flag.ExitOnError)
+	sections := flagSet.String("sections", "", "comma-separated sections to fetch: ranges,outline,errors,folds")
+	encoding := flagSet.String("encoding", EncodingBytes, "units of columns and lengths: bytes, utf-16 or runes")
+	this.CommandArgs = ParseCommandFlags(flagSet, this.CommandArgs)
+	if len(this.CommandArgs) == 0 {
+		panic(errors.New("missed <path> parameters"))
+	}
+	files := make([]ArgsBatchFile, 0, len(this.CommandArgs))
+	for _, arg := range this.CommandArgs {
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			panic(err)
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			panic(err)
+		}
+		files = append(files, ArgsBatchFile{Path: path, Content: content})
+	}
+	context := PackGoBuildContext(&build.Default)
+	results := ClientReindexBatch(this.RpcClient, files, context, IndexerOptions{Sections: ParseSectionsFlag(*sections), Encoding: ParseEncodingFlag(*encoding)})
 	jsonBytes, err := json.Marshal(results)
 	if err != nil {
 		panic(err)
@@ -124,7 +259,146 @@ func (this *Client) ExecClose() {
 
 func (this *Client) ExecStatus() {
 	status := ClientStatus(this.RpcClient)
-	fmt.Printf("Daemon status: '%s'\n", status)
+	fmt.Printf("Daemon status: '%s'\n", status.Status)
+	for _, workspace := range status.Workspaces {
+		root := workspace.Root
+		if workspace.Implicit {
+			root = "<files outside of workspaces>"
+		}
+		fmt.Printf("Workspace '%s' (%s): %d requests, %d cached packages, last used %s\n",
+			root, workspace.Name, workspace.Requests, workspace.CachedPackages,
+			workspace.LastUsed.Format(time.Stamp))
+	}
+}
+
+func (this *Client) ExecWorkspace() int {
+	flagSet := flag.NewFlagSet("workspace", flag.ExitOnError)
+	name := flagSet.String("name", "", "workspace name shown in status")
+	maxPackages := flagSet.Int("max-packages", 0, "limit of cached imported packages")
+	args := ParseCommandFlags(flagSet, this.CommandArgs)
+	if len(args) != 2 {
+		panic(errors.New("expected 'workspace add <root>' or 'workspace remove <root>'"))
+	}
+	root, err := filepath.Abs(args[1])
+	if err != nil {
+		panic(err)
+	}
+	switch args[0] {
+	case "add":
+		settings := WorkspaceSettings{
+			Name:              *name,
+			MaxCachedPackages: *maxPackages,
+		}
+		if len(settings.Name) == 0 {
+			settings.Name = filepath.Base(root)
+		}
+		ClientRegisterWorkspace(this.RpcClient, root, PackGoBuildContext(&build.Default), settings)
+	case "remove":
+		if !ClientUnregisterWorkspace(this.RpcClient, root) {
+			fmt.Printf("Workspace '%s' not registered\n", root)
+			return 1
+		}
+	default:
+		panic(errors.New("unknown workspace subcommand " + args[0]))
+	}
+	return 0
+}
+
+// Parses -sections flag value, panics on unknown section. Empty list means
+// all sections.
+func ParseSectionsFlag(list string) []string {
+	sections, err := ParseSections(list)
+	if err != nil {
+		panic(err)
+	}
+	return sections
+}
+
+// Checks -encoding flag value, panics on unknown encoding
+func ParseEncodingFlag(encoding string) string {
+	if err := CheckPositionEncoding(encoding); err != nil {
+		panic(err)
+	}
+	return encoding
+}
+
+// Parses command flags placed before, after or between positional arguments
+func ParseCommandFlags(flagSet *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flagSet.Parse(args)
+		args = flagSet.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	return positional
+}
+
+func (this *Client) ExecCrashes() int {
+	if len(this.CommandArgs) == 0 {
+		ids, err := ListCrashReports()
+		if err != nil {
+			panic(err)
+		}
+		for _, id := range ids {
+			report, err := LoadCrashReport(id)
+			if err != nil {
+				fmt.Printf("%s\t<%s>\n", id, err.Error())
+				continue
+			}
+			fmt.Printf("%s\t%s\t%s\n", id, report.Path, strings.SplitN(report.Panic, "\n", 2)[0])
+		}
+		return 0
+	}
+	if len(this.CommandArgs) != 2 {
+		panic(errors.New("expected 'crashes', 'crashes show <id>' or 'crashes replay <id>'"))
+	}
+	report, err := LoadCrashReport(this.CommandArgs[1])
+	if err != nil {
+		panic(err)
+	}
+	switch this.CommandArgs[0] {
+	case "show":
+		fmt.Printf("Path: %s\nTime: %v\nPanic: %s\n\n%s\n", report.Path, report.Time, report.Panic, report.Stack)
+	case "replay":
+		result, panicErr, stack := report.Replay()
+		if panicErr != nil {
+			fmt.Printf("Reproduced panic: %v\n\n%s\n", panicErr, stack)
+			return 1
+		}
+		jsonBytes, err := json.Marshal(result)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Panic not reproduced, result:\n%s\n", string(jsonBytes))
+	default:
+		panic(errors.New("unknown crashes subcommand " + this.CommandArgs[0]))
+	}
+	return 0
+}
+
+// Query commands get unsaved editor buffer from stdin like highlight, but
+// keep it as is since offsets point into it. -in=<path> reads file from
+// disk instead.
+func (this *Client) ReadEditorFile() ([]byte, string) {
+	if len(g_app.Input) != 0 {
+		return this.ReadSourceFile()
+	}
+	if len(this.CommandArgs) == 0 {
+		panic(errors.New("missed <path> parameter or -in=<path> option"))
+	}
+	path, err := filepath.Abs(this.CommandArgs[0])
+	if err != nil {
+		panic(err)
+	}
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		panic(err)
+	}
+	return content, path
 }
 
 func (this *Client) PrepareFileTraits() ([]byte, string) {
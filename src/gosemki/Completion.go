@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Keywords are offered wherever identifier is expected
+var g_goKeywords = []string{
+	"break", "case", "chan", "const", "continue", "default", "defer", "else",
+	"fallthrough", "for", "func", "go", "goto", "if", "import", "interface",
+	"map", "package", "range", "return", "select", "struct", "switch", "type", "var",
+}
+
+// Types used only to describe signatures of builtin funcs
+var g_builtinPlaceholderTypes = []string{"Type", "Type1", "IntegerType", "FloatType", "ComplexType"}
+
+// Lists names which can be typed at byte offset of the file sent by
+// editor. Start is offset of already typed part of name, candidates begin
+// with it. After 'pkg.' members of imported package are listed, after
+// 'value.' fields and methods of inferred type, otherwise names declared
+// in scope and keywords.
+func (this *PackageIndexer) Complete(filePath string, content []byte, offset int) (int, []GoCandidate, error) {
+	if offset < 0 || offset > len(content) {
+		return offset, nil, errors.New(fmt.Sprintf("offset %d is out of file", offset))
+	}
+	start := offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRune(content[:start])
+		if !IsIdentRune(r) {
+			break
+		}
+		start -= size
+	}
+	prefix := string(content[start:offset])
+	if len(prefix) != 0 && unicode.IsDigit([]rune(prefix)[0]) {
+		// number literal
+		return start, nil, nil
+	}
+	isSelector := start > 0 && content[start-1] == '.'
+	if isSelector && len(prefix) == 0 {
+		// parser needs selected name, otherwise next line is selected
+		patched := make([]byte, 0, len(content)+1)
+		patched = append(append(append(patched, content[:offset]...), '_'), content[offset:]...)
+		content = patched
+	}
+	this.ResolvePackage(map[string][]byte{filePath: content})
+	file := this.files[filePath]
+	// file without package clause has no position, but its token file
+	// is kept to complete keywords and predeclared names while typing
+	pos := token.NoPos
+	if tokenFile := this.GetTokenFile(filePath); tokenFile != nil {
+		pos = tokenFile.Pos(start)
+	}
+	if IsInsideCommentOrLiteral(file, pos) {
+		return start, nil, nil
+	}
+	inferrer := NewTypeInferrer(this)
+	var candidates []GoCandidate
+	if isSelector {
+		_, selector := FindIdentAtPos(file, pos)
+		if selector == nil {
+			return start, nil, nil
+		}
+		candidates = this.CompleteSelector(inferrer, selector)
+	} else {
+		candidates = this.CompleteScope(inferrer, file, pos)
+	}
+	return start, FilterCandidates(candidates, prefix), nil
+}
+
+func IsIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Names are not completed in comments, strings and rune literals
+func IsInsideCommentOrLiteral(file *ast.File, pos token.Pos) bool {
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			// line comment lasts till end of line
+			isLineComment := strings.HasPrefix(comment.Text, "//")
+			if comment.Pos() < pos && (pos < comment.End() || (pos == comment.End() && isLineComment)) {
+				return true
+			}
+		}
+	}
+	inside := false
+	ast.Inspect(file, func(node ast.Node) bool {
+		if node == nil || inside || pos <= node.Pos() || pos > node.End() {
+			return false
+		}
+		if literal, ok := node.(*ast.BasicLit); ok && (literal.Kind == token.STRING || literal.Kind == token.CHAR) {
+			// unterminated literal ends at cursor
+			inside = pos < literal.End() || !IsTerminatedLiteral(literal.Value)
+		}
+		return true
+	})
+	return inside
+}
+
+func IsTerminatedLiteral(value string) bool {
+	if len(value) < 2 {
+		return false
+	}
+	last := value[len(value)-1]
+	return last == value[0] && (last == '`' || value[len(value)-2] != '\\')
+}
+
+// Members of imported package or fields and methods of selected value
+func (this *PackageIndexer) CompleteSelector(inferrer *TypeInferrer, selector *ast.SelectorExpr) (candidates []GoCandidate) {
+	if pkg := this.GetSelectedPackage(selector); pkg != nil {
+		for _, obj := range pkg.Scope.Objects {
+			candidates = append(candidates, this.MakeCandidate(inferrer, obj, pkg))
+		}
+		return
+	}
+	valueType, ok := inferrer.InferExpr(selector.X, nil)
+	if !ok {
+		return
+	}
+	for _, member := range inferrer.CollectMembers(valueType) {
+		candidates = append(candidates, GoCandidate{
+			Name:      member.Name,
+			Kind:      member.Kind,
+			Signature: inferrer.FormatType(member.Type),
+		})
+	}
+	return
+}
+
+// Locals visible at the position, package members, predeclared
+// identifiers, imported packages and keywords
+func (this *PackageIndexer) CompleteScope(inferrer *TypeInferrer, file *ast.File, pos token.Pos) (candidates []GoCandidate) {
+	locals := CollectLocalIdents(file, pos)
+	// inner declarations shadow outer ones
+	for i := len(locals) - 1; i >= 0; i-- {
+		ident := locals[i]
+		if ident.Name == "_" {
+			continue
+		}
+		candidate := GoCandidate{Name: ident.Name, Kind: GoKindVar}
+		if ident.Obj != nil {
+			candidate = this.MakeCandidate(inferrer, ident.Obj, nil)
+		}
+		candidates = append(candidates, candidate)
+	}
+	for _, obj := range this.packageScope.Objects {
+		if this.IsBuiltinObject(obj) && obj.Kind == ast.Typ && ContainsString(g_builtinPlaceholderTypes, obj.Name) {
+			continue
+		}
+		candidates = append(candidates, this.MakeCandidate(inferrer, obj, nil))
+	}
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if pkg := this.imported[path]; pkg != nil {
+			// package name can differ from directory name
+			for _, pkgFile := range pkg.Files {
+				name = pkgFile.Name.Name
+				break
+			}
+		}
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name != "_" && name != "." {
+			candidates = append(candidates, GoCandidate{Name: name, Kind: GoKindPkg, Signature: spec.Path.Value})
+		}
+	}
+	for _, keyword := range g_goKeywords {
+		candidates = append(candidates, GoCandidate{Name: keyword, Kind: GoKindKeyword})
+	}
+	return
+}
+
+// Candidate for declared object, pkg is package where object is declared
+func (this *PackageIndexer) MakeCandidate(inferrer *TypeInferrer, obj *ast.Object, pkg *CachedPackage) GoCandidate {
+	candidate := GoCandidate{Name: obj.Name, Kind: inferObjectKind(obj)}
+	if candidate.Kind == GoKindField {
+		// params and results, struct fields are not declared in scopes
+		candidate.Kind = GoKindVar
+	}
+	switch obj.Kind {
+	case ast.Typ:
+		if spec, ok := obj.Decl.(*ast.TypeSpec); ok {
+			switch spec.Type.(type) {
+			case *ast.StructType:
+				candidate.Signature = "struct"
+			case *ast.InterfaceType:
+				candidate.Signature = "interface"
+			default:
+				candidate.Signature = inferrer.FormatType(TypeRef{spec.Type, pkg})
+			}
+		}
+	case ast.Var:
+		if pkg == nil && this.IsBuiltinObject(obj) {
+			// nil has no type
+			break
+		}
+		fallthrough
+	case ast.Con, ast.Fun:
+		if ref, ok := inferrer.InferObject(obj, pkg); ok {
+			candidate.Signature = inferrer.FormatType(ref)
+		}
+	}
+	return candidate
+}
+
+// Collects identifiers declared inside of functions before the position,
+// outer declarations go first
+func CollectLocalIdents(file *ast.File, pos token.Pos) (idents []*ast.Ident) {
+	addFields := func(fields *ast.FieldList) {
+		if fields != nil {
+			for _, field := range fields.List {
+				idents = append(idents, field.Names...)
+			}
+		}
+	}
+	addDeclared := func(stmt ast.Stmt) {
+		if stmt != nil && stmt.End() <= pos {
+			idents = append(idents, GetDeclaredIdents(stmt)...)
+		}
+	}
+	ast.Inspect(file, func(node ast.Node) bool {
+		if node == nil || pos < node.Pos() || pos > node.End() {
+			return false
+		}
+		switch x := node.(type) {
+		case *ast.FuncDecl:
+			if x.Body != nil && x.Body.Pos() < pos {
+				addFields(x.Recv)
+				addFields(x.Type.Params)
+				addFields(x.Type.Results)
+			}
+		case *ast.FuncLit:
+			if x.Body.Pos() < pos {
+				addFields(x.Type.Params)
+				addFields(x.Type.Results)
+			}
+		case *ast.BlockStmt:
+			for _, stmt := range x.List {
+				addDeclared(stmt)
+			}
+		case *ast.IfStmt:
+			addDeclared(x.Init)
+		case *ast.ForStmt:
+			addDeclared(x.Init)
+		case *ast.SwitchStmt:
+			addDeclared(x.Init)
+		case *ast.TypeSwitchStmt:
+			addDeclared(x.Init)
+			if x.Body.Pos() < pos {
+				addDeclared(x.Assign)
+			}
+		case *ast.RangeStmt:
+			if x.Tok == token.DEFINE && x.Body.Pos() < pos {
+				for _, expr := range []ast.Expr{x.Key, x.Value} {
+					if ident, ok := expr.(*ast.Ident); ok {
+						idents = append(idents, ident)
+					}
+				}
+			}
+		case *ast.CommClause:
+			addDeclared(x.Comm)
+		}
+		return true
+	})
+	return
+}
+
+// Names declared by statement: short variable declarations and var, const
+// and type declarations
+func GetDeclaredIdents(stmt ast.Stmt) (idents []*ast.Ident) {
+	switch x := stmt.(type) {
+	case *ast.AssignStmt:
+		if x.Tok == token.DEFINE {
+			for _, expr := range x.Lhs {
+				if ident, ok := expr.(*ast.Ident); ok {
+					idents = append(idents, ident)
+				}
+			}
+		}
+	case *ast.DeclStmt:
+		if genDecl, ok := x.Decl.(*ast.GenDecl); ok {
+			for _, spec := range genDecl.Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					idents = append(idents, spec.Names...)
+				case *ast.TypeSpec:
+					idents = append(idents, spec.Name)
+				}
+			}
+		}
+	case *ast.LabeledStmt:
+		return GetDeclaredIdents(x.Stmt)
+	}
+	return
+}
+
+// Keeps candidates starting with typed prefix, the first of candidates
+// with the same name wins. Result is sorted by name.
+func FilterCandidates(candidates []GoCandidate, prefix string) []GoCandidate {
+	seen := make(map[string]bool)
+	filtered := make([]GoCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if seen[candidate.Name] || candidate.Name == "_" || !strings.HasPrefix(candidate.Name, prefix) {
+			continue
+		}
+		seen[candidate.Name] = true
+		filtered = append(filtered, candidate)
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].Name < filtered[j].Name
+	})
+	return filtered
+}
+
+func GetCandidateKindName(kind GoKind) string {
+	switch kind {
+	case GoKindPkg:
+		return "package"
+	case GoKindKeyword:
+		return "keyword"
+	}
+	return GetOutlineKindName(kind, GoKindBad)
+}
+
+// Prints candidates for name at offset, one per line
+func (this *Client) ExecComplete() int {
+	flagSet := flag.NewFlagSet("complete", flag.ExitOnError)
+	offset := flagSet.Int("offset", -1, "byte offset of cursor in file")
+	asJson := flagSet.Bool("json", false, "print candidates as JSON")
+	this.CommandArgs = ParseCommandFlags(flagSet, this.CommandArgs)
+	if *offset < 0 {
+		panic(errors.New("missed -offset=<offset> option"))
+	}
+	content, path := this.ReadEditorFile()
+	context := PackGoBuildContext(&build.Default)
+	reply := ClientComplete(this.RpcClient, content, path, context, *offset)
+	if len(reply.Reason) != 0 {
+		fmt.Fprintf(os.Stderr, "%s\n", reply.Reason)
+		return 1
+	}
+	if *asJson {
+		jsonBytes, err := json.Marshal(struct {
+			Start      int           `json:"start"`
+			Candidates []GoCandidate `json:"candidates"`
+		}{reply.Start, reply.Candidates})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", string(jsonBytes))
+		return 0
+	}
+	for _, candidate := range reply.Candidates {
+		line := GetCandidateKindName(candidate.Kind) + " " + candidate.Name + " " + candidate.Signature
+		fmt.Printf("%s\n", strings.TrimSpace(line))
+	}
+	return 0
+}
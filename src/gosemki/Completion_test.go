@@ -0,0 +1,31 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+	"testing"
+)
+
+// New file being typed has no package clause yet, completion still offers
+// keywords and predeclared names
+func TestCompleteWithoutPackageClause(t *testing.T) {
+	for _, content := range []string{"", "func f() { le"} {
+		var result IndexerResult
+		indexer := NewPackageIndexer(&result)
+		indexer.context = build.Default
+		_, candidates, err := indexer.Complete(filepath.Join(t.TempDir(), "new.go"), []byte(content), len(content))
+		if err != nil {
+			t.Fatalf("%q: %v", content, err)
+		}
+		names := make(map[string]bool)
+		for _, candidate := range candidates {
+			names[candidate.Name] = true
+		}
+		if !names["len"] {
+			t.Errorf("%q: expected predeclared 'len' in %+v", content, candidates)
+		}
+		if len(content) == 0 && !names["package"] {
+			t.Errorf("%q: expected keyword 'package' in %+v", content, candidates)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	CRASH_REPORT_PREFIX = "crash-"
+	CRASH_REPORT_SUFFIX = ".json"
+)
+
+// Everything needed to reproduce indexer panic without editor
+type CrashReport struct {
+	Id      string
+	Time    time.Time
+	Panic   string
+	Stack   string
+	Context GoBuildContext
+	Options IndexerOptions
+	Path    string
+	Content string
+	// other unsaved files indexed in the same pass
+	Siblings map[string]string `json:",omitempty"`
+	// query like "definition" which crashed instead of indexing
+	Query  string `json:",omitempty"`
+	Offset int    `json:",omitempty"`
+}
+
+func GetCrashReportsDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "gosemki", "crashes")
+}
+
+// Should be called from deferred function which recovered panic,
+// so stack includes the place where panic occured
+func NewCrashReport(panicErr interface{}, context GoBuildContext, options IndexerOptions, overlays map[string][]byte) *CrashReport {
+	now := time.Now()
+	ret := &CrashReport{
+		Id:      now.Format("20060102-150405.000000000"),
+		Time:    now,
+		Panic:   fmt.Sprintf("%v", panicErr),
+		Stack:   string(debug.Stack()),
+		Context: context,
+		Options: options,
+	}
+	paths := make([]string, 0, len(overlays))
+	for path := range overlays {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for i, path := range paths {
+		if i == 0 {
+			ret.Path = path
+			ret.Content = string(overlays[path])
+			continue
+		}
+		if ret.Siblings == nil {
+			ret.Siblings = make(map[string]string)
+		}
+		ret.Siblings[path] = string(overlays[path])
+	}
+	return ret
+}
+
+func (this *CrashReport) Save() (string, error) {
+	dir := GetCrashReportsDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	jsonBytes, err := json.MarshalIndent(this, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, CRASH_REPORT_PREFIX+this.Id+CRASH_REPORT_SUFFIX)
+	return path, ioutil.WriteFile(path, jsonBytes, 0600)
+}
+
+// Saves report and prints where, errors are only printed
+func (this *CrashReport) SaveAndPrint() {
+	if reportPath, err := this.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save crash report: %s\n", err.Error())
+	} else {
+		fmt.Fprintf(os.Stderr, "crash report saved to '%s'\n", reportPath)
+	}
+}
+
+func LoadCrashReport(id string) (*CrashReport, error) {
+	path := filepath.Join(GetCrashReportsDir(), CRASH_REPORT_PREFIX+id+CRASH_REPORT_SUFFIX)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := new(CrashReport)
+	if err = json.Unmarshal(content, report); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to parse crash report '%s': %s", path, err.Error()))
+	}
+	return report, nil
+}
+
+// Returns identifiers of saved crash reports, oldest first
+func ListCrashReports() ([]string, error) {
+	names, err := ioutil.ReadDir(GetCrashReportsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, info := range names {
+		name := info.Name()
+		if strings.HasPrefix(name, CRASH_REPORT_PREFIX) && strings.HasSuffix(name, CRASH_REPORT_SUFFIX) {
+			ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(name, CRASH_REPORT_PREFIX), CRASH_REPORT_SUFFIX))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Runs fresh in-process indexer or query on the saved input.
+// Returns nil panic if crash was not reproduced.
+func (this *CrashReport) Replay() (result IndexerResult, panicErr interface{}, stack string) {
+	defer func() {
+		if err := recover(); err != nil {
+			panicErr = err
+			stack = string(debug.Stack())
+		}
+	}()
+	overlays := map[string][]byte{this.Path: []byte(this.Content)}
+	results := map[string]*IndexerResult{this.Path: &result}
+	for path, content := range this.Siblings {
+		overlays[path] = []byte(content)
+		results[path] = new(IndexerResult)
+	}
+	indexer := new(PackageIndexer)
+	indexer.context = UnpackGoBuildContext(&this.Context)
+	indexer.options = this.Options
+	content := []byte(this.Content)
+	switch this.Query {
+	case "":
+		indexer.ReindexFiles(overlays, results)
+	case "definition":
+		indexer.FindDefinition(this.Path, content, this.Offset)
+	case "references":
+		indexer.FindReferences(this.Path, content, this.Offset, true)
+	case "hover":
+		indexer.FindHover(this.Path, content, this.Offset)
+	case "complete":
+		indexer.Complete(this.Path, content, this.Offset)
+	default:
+		panic(errors.New("unknown query '" + this.Query + "' in crash report"))
+	}
+	return
+}
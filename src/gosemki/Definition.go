@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"os"
+	"strconv"
+)
+
+// Finds declaration of identifier at byte offset of the file sent by
+// editor. Declarations can be in the same file, in other package file or
+// in imported package.
+func (this *PackageIndexer) FindDefinition(filePath string, content []byte, offset int) (GoDefinition, error) {
+	ident, selector, err := this.FindIdentAt(filePath, content, offset)
+	if err != nil {
+		return GoDefinition{}, err
+	}
+	obj, fset := this.ResolveIdent(ident, selector)
+	if obj == nil {
+		return GoDefinition{}, errors.New(fmt.Sprintf("declaration of '%s' not found", ident.Name))
+	}
+	return MakeDefinition(obj, fset)
+}
+
+// Resolves package of the file, then finds identifier at the offset.
+// Selector is set if identifier is selected from other expression.
+func (this *PackageIndexer) FindIdentAt(filePath string, content []byte, offset int) (*ast.Ident, *ast.SelectorExpr, error) {
+	this.ResolvePackage(map[string][]byte{filePath: content})
+	file := this.files[filePath]
+	// file without package clause has no position
+	tokenFile := this.GetTokenFile(filePath)
+	if tokenFile == nil {
+		return nil, nil, errors.New(fmt.Sprintf("no identifier at offset %d", offset))
+	}
+	if offset < 0 || offset > tokenFile.Size() {
+		return nil, nil, errors.New(fmt.Sprintf("offset %d is out of file", offset))
+	}
+	ident, selector := FindIdentAtPos(file, tokenFile.Pos(offset))
+	if ident == nil {
+		return nil, nil, errors.New(fmt.Sprintf("no identifier at offset %d", offset))
+	}
+	return ident, selector, nil
+}
+
+// Cursor right after identifier also selects it
+func FindIdentAtPos(file *ast.File, pos token.Pos) (found *ast.Ident, selector *ast.SelectorExpr) {
+	ast.Inspect(file, func(node ast.Node) bool {
+		if node == nil || found != nil || pos < node.Pos() || pos > node.End() {
+			return false
+		}
+		switch x := node.(type) {
+		case *ast.SelectorExpr:
+			if x.Sel.Pos() <= pos && pos <= x.Sel.End() {
+				found, selector = x.Sel, x
+				return false
+			}
+		case *ast.Ident:
+			found = x
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// Returns declared object and FileSet of the file where it's declared,
+// nil if identifier is not resolved. go/ast doesn't resolve fields and
+// methods, they are looked up in inferred type of selected expression or
+// struct literal.
+func (this *PackageIndexer) ResolveIdent(ident *ast.Ident, selector *ast.SelectorExpr) (*ast.Object, *token.FileSet) {
+	if selector != nil {
+		if pkg := this.GetSelectedPackage(selector); pkg != nil {
+			return pkg.Scope.Lookup(ident.Name), pkg.Fset
+		}
+		inferrer := NewTypeInferrer(this)
+		// method expression like T.Method selects from type
+		operand := TypeRef{selector.X, nil}
+		if !inferrer.IsTypeExpr(unparen(selector.X), nil) {
+			var ok bool
+			if operand, ok = inferrer.InferExpr(selector.X, nil); !ok {
+				return nil, nil
+			}
+		}
+		return this.ResolveMember(inferrer, operand, ident.Name)
+	}
+	switch parent := this.GetMemberParent(ident).(type) {
+	case *ast.FuncDecl:
+		return NewMemberObject(ast.Fun, ident, parent), this.fset
+	case *ast.CompositeLit:
+		inferrer := NewTypeInferrer(this)
+		if literalType, ok := inferrer.InferExpr(parent, nil); ok {
+			// keys of other literals are expressions
+			if _, isStruct := inferrer.Underlying(literalType).Expr.(*ast.StructType); isStruct {
+				return this.ResolveMember(inferrer, literalType, ident.Name)
+			}
+		}
+	}
+	if ident.Obj == nil {
+		return nil, nil
+	}
+	// names of dot-imported packages are declared in their scopes
+	for _, pkg := range this.imported {
+		if pkg.Scope.Lookup(ident.Name) == ident.Obj {
+			return ident.Obj, pkg.Fset
+		}
+	}
+	return ident.Obj, this.fset
+}
+
+// Finds field or method of the type, FileSet is of the package where
+// member is declared
+func (this *PackageIndexer) ResolveMember(inferrer *TypeInferrer, ref TypeRef, name string) (*ast.Object, *token.FileSet) {
+	member := FindMember(inferrer.CollectMembers(ref), name)
+	if member == nil {
+		return nil, nil
+	}
+	if member.Type.Pkg != nil {
+		return member.Obj, member.Type.Pkg.Fset
+	}
+	return member.Obj, this.fset
+}
+
+// Returns method declaration if identifier is its name, composite literal
+// if identifier is its key, nil otherwise. Such identifiers of package
+// files are collected on first call.
+func (this *PackageIndexer) GetMemberParent(ident *ast.Ident) ast.Node {
+	if this.memberParents == nil {
+		this.memberParents = make(map[*ast.Ident]ast.Node)
+		for _, file := range this.files {
+			ast.Inspect(file, func(node ast.Node) bool {
+				switch x := node.(type) {
+				case *ast.FuncDecl:
+					if x.Recv != nil {
+						this.memberParents[x.Name] = x
+					}
+				case *ast.CompositeLit:
+					for _, element := range x.Elts {
+						if pair, ok := element.(*ast.KeyValueExpr); ok {
+							if key, ok := pair.Key.(*ast.Ident); ok {
+								this.memberParents[key] = x
+							}
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+	return this.memberParents[ident]
+}
+
+// Returns imported package if selector is qualified identifier like
+// io.Reader, nil otherwise
+func (this *PackageIndexer) GetSelectedPackage(selector *ast.SelectorExpr) *CachedPackage {
+	x, ok := selector.X.(*ast.Ident)
+	if !ok || x.Obj == nil || x.Obj.Kind != ast.Pkg {
+		return nil
+	}
+	spec, ok := x.Obj.Decl.(*ast.ImportSpec)
+	if !ok {
+		return nil
+	}
+	path, _ := strconv.Unquote(spec.Path.Value)
+	return this.imported[path]
+}
+
+// Returns nil if FileSet is not of imported package
+func (this *PackageIndexer) GetImportedByFileSet(fset *token.FileSet) *CachedPackage {
+	for _, pkg := range this.imported {
+		if pkg.Fset == fset {
+			return pkg
+		}
+	}
+	return nil
+}
+
+func MakeDefinition(obj *ast.Object, fset *token.FileSet) (GoDefinition, error) {
+	decl, ok := obj.Decl.(ast.Node)
+	if !ok {
+		return GoDefinition{}, errors.New(fmt.Sprintf("declaration of '%s' not found", obj.Name))
+	}
+	name := FindDeclaredName(obj, decl)
+	position := fset.Position(name.Pos())
+	if len(position.Filename) == 0 {
+		// builtin declarations are injected from string
+		return GoDefinition{}, errors.New(fmt.Sprintf("'%s' is predeclared identifier", obj.Name))
+	}
+	return GoDefinition{
+		GoLocation: MakeLocation(fset, name),
+		Decl: GoSpan{
+			Start: MakeGoPosInFileSet(fset, decl.Pos()),
+			End:   MakeGoPosInFileSet(fset, decl.End()),
+		},
+		Name: obj.Name,
+		Kind: inferObjectKind(obj),
+	}, nil
+}
+
+func MakeLocation(fset *token.FileSet, node ast.Node) GoLocation {
+	return GoLocation{
+		GoPos: MakeGoPosInFileSet(fset, node.Pos()),
+		End:   MakeGoPosInFileSet(fset, node.End()),
+		Path:  fset.Position(node.Pos()).Filename,
+	}
+}
+
+// Returns identifier which declares the object, or whole declaration if
+// there is no such identifier, e.g. import without name
+func FindDeclaredName(obj *ast.Object, decl ast.Node) ast.Node {
+	switch x := decl.(type) {
+	case *ast.ImportSpec:
+		if x.Name != nil {
+			return x.Name
+		}
+		return x.Path
+	case *ast.FuncDecl:
+		// names of methods are not declared in scopes
+		return x.Name
+	case *ast.Field:
+		if len(x.Names) == 0 {
+			if name := GetEmbeddedName(x.Type); name != nil {
+				return name
+			}
+		}
+	}
+	var name ast.Node = decl
+	ast.Inspect(decl, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Ident); ok && ident.Obj == obj {
+			name = ident
+		}
+		return name == decl
+	})
+	return name
+}
+
+func (this *Client) ExecDefinition() int {
+	flagSet := flag.NewFlagSet("definition", flag.ExitOnError)
+	offset := flagSet.Int("offset", -1, "byte offset of identifier in file")
+	asJson := flagSet.Bool("json", false, "print definition as JSON")
+	this.CommandArgs = ParseCommandFlags(flagSet, this.CommandArgs)
+	if *offset < 0 {
+		panic(errors.New("missed -offset=<offset> option"))
+	}
+	content, path := this.ReadEditorFile()
+	context := PackGoBuildContext(&build.Default)
+	reply := ClientFindDefinition(this.RpcClient, content, path, context, *offset)
+	if !reply.Found {
+		fmt.Fprintf(os.Stderr, "%s\n", reply.Reason)
+		return 1
+	}
+	if *asJson {
+		jsonBytes, err := json.Marshal(reply.Definition)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", string(jsonBytes))
+		return 0
+	}
+	definition := reply.Definition
+	fmt.Printf("%s:%d:%d\n", GetDisplayPath(definition.Path), definition.Line, definition.Column)
+	return 0
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// File without package clause has no identifiers, query must fail
+// instead of panic
+func TestFindDefinitionWithoutPackageClause(t *testing.T) {
+	for _, content := range []string{"", "func f() {}\n"} {
+		var result IndexerResult
+		indexer := NewPackageIndexer(&result)
+		indexer.context = build.Default
+		_, err := indexer.FindDefinition(filepath.Join(t.TempDir(), "new.go"), []byte(content), 0)
+		if err == nil || err.Error() != "no identifier at offset 0" {
+			t.Errorf("%q: unexpected error %v", content, err)
+		}
+	}
+}
+
+const DEFINITION_TEST_SOURCE = `package sample
+
+type Point struct {
+	X, Y int
+}
+
+func (p Point) Len() int {
+	return p.X + p.Y
+}
+
+var X = Point{X: 1}.Len()
+`
+
+// Fields and methods are found in inferred type of selected value or
+// struct literal, not by name in package scope
+func TestFindDefinitionOfMembers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample.go")
+	for _, test := range []struct{ use, decl string }{
+		{"X + p.Y", "X, Y int"},
+		{"Y\n", "Y int"},
+		{"X: 1", "X, Y int"},
+		{"Len()\n", "Len() int {"},
+		{"X = Point", "X = Point"},
+	} {
+		var result IndexerResult
+		indexer := NewPackageIndexer(&result)
+		indexer.context = build.Default
+		definition, err := indexer.FindDefinition(path, []byte(DEFINITION_TEST_SOURCE), strings.Index(DEFINITION_TEST_SOURCE, test.use))
+		if err != nil {
+			t.Errorf("%q: %v", test.use, err)
+			continue
+		}
+		if expected := strings.Index(DEFINITION_TEST_SOURCE, test.decl); definition.Offset != expected {
+			t.Errorf("%q: expected declaration at offset %d, got %d", test.use, expected, definition.Offset)
+		}
+	}
+}
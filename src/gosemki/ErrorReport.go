@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Error with the file where it was found
+type FileError struct {
+	Path string // as shown to user, relative to working dir if possible
+	GoError
+}
+
+// Errors sorted by path and offset, with all checked files (CI reports
+// list files without errors too)
+type ErrorReport struct {
+	Files  []string
+	Errors []FileError
+}
+
+// Writes errors of checked files in the format expected by editor or CI tool
+type ErrorFormatter func(writer io.Writer, report *ErrorReport)
+
+var g_errorFormatters = map[string]ErrorFormatter{
+	"quickfix":   FormatQuickfixErrors,
+	"gcc":        FormatGccErrors,
+	"emacs":      FormatEmacsErrors,
+	"sarif":      FormatSarifErrors,
+	"checkstyle": FormatCheckstyleErrors,
+	"junit":      FormatJunitErrors,
+}
+
+// Checks package of given file (or all packages in given directory,
+// 'dir/...' includes subdirectories) and prints errors of all its files.
+// Exit code is 1 if errors found, 2 if check failed.
+func (this *Client) ExecErrors() (exitCode int) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			PrintBacktrace(panicErr)
+			exitCode = 2
+		}
+	}()
+	flagSet := flag.NewFlagSet("errors", flag.ExitOnError)
+	format := flagSet.String("format", "quickfix", "output format: quickfix, gcc, emacs, sarif, checkstyle or junit")
+	args := ParseCommandFlags(flagSet, this.CommandArgs)
+	formatter := g_errorFormatters[*format]
+	if formatter == nil {
+		fmt.Fprintf(os.Stderr, "Unknown errors format '%s'\n", *format)
+		return 2
+	}
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Expected single <path> parameter\n")
+		return 2
+	}
+	files, err := CollectPackageFiles(&build.Default, args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 2
+	}
+	if err = this.Connect(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 2
+	}
+	defer this.RpcClient.Close()
+	options := IndexerOptions{Sections: []string{SectionErrors}, StrictErrors: true}
+	if *format == "sarif" {
+		options.Encoding = EncodingUtf16
+	}
+	context := PackGoBuildContext(&build.Default)
+	results, err := CallReindexBatch(this.RpcClient, files, context, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Daemon failed to check '%s': %s\n", args[0], err.Error())
+		return 2
+	}
+
+	var report ErrorReport
+	for _, file := range files {
+		result := results[file.Path]
+		if result == nil {
+			continue
+		}
+		report.Files = append(report.Files, GetDisplayPath(file.Path))
+		if result.InPanic {
+			fmt.Fprintf(os.Stderr, "Daemon failed to check '%s', see 'gosemki crashes'\n", file.Path)
+			return 2
+		}
+		for _, goError := range result.Errors {
+			if *format == "emacs" {
+				// emacs counts screen columns with expanded tabs
+				goError.Column = GetScreenColumn(file.Content, goError.GoPos)
+				goError.End.Column = GetScreenColumn(file.Content, goError.End)
+			}
+			report.Errors = append(report.Errors, FileError{Path: GetDisplayPath(file.Path), GoError: goError})
+		}
+	}
+	sort.Strings(report.Files)
+	sort.SliceStable(report.Errors, func(i, j int) bool {
+		if report.Errors[i].Path != report.Errors[j].Path {
+			return report.Errors[i].Path < report.Errors[j].Path
+		}
+		return report.Errors[i].Offset < report.Errors[j].Offset
+	})
+	formatter(os.Stdout, &report)
+	if len(report.Errors) != 0 {
+		return 1
+	}
+	return 0
+}
+
+// Reads files of the package containing given file, or files of all
+// packages in given directory, skips files excluded by build constraints
+func CollectPackageFiles(context *build.Context, path string) ([]ArgsBatchFile, error) {
+	var files []ArgsBatchFile
+	var err error
+	if root := strings.TrimSuffix(path, "..."); root != path {
+		files, err = CollectTreeFiles(context, root)
+	} else {
+		files, err = ReadPackageFiles(context, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no Go files in '" + path + "'")
+	}
+	return files, nil
+}
+
+func ReadPackageFiles(context *build.Context, path string) ([]ArgsBatchFile, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := path
+	packageName := ""
+	if !info.IsDir() {
+		dir = filepath.Dir(path)
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		packageName = ParsePackageName(path, content)
+	}
+	names, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []ArgsBatchFile
+	for _, name := range names {
+		filePath := filepath.Join(dir, name.Name())
+		if name.IsDir() || !strings.HasSuffix(name.Name(), ".go") {
+			continue
+		}
+		if filePath != path {
+			if match, err := context.MatchFile(dir, name.Name()); err == nil && !match {
+				continue
+			}
+		}
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if len(packageName) != 0 && filePath != path && ParsePackageName(filePath, content) != packageName {
+			continue
+		}
+		files = append(files, ArgsBatchFile{Path: filePath, Content: content})
+	}
+	return files, nil
+}
+
+// Reads files of all packages in directory tree
+func CollectTreeFiles(context *build.Context, root string) ([]ArgsBatchFile, error) {
+	if len(root) == 0 {
+		root = "."
+	}
+	var files []ArgsBatchFile
+	var readErr error
+	err := WalkPackageDirs(root, func(dir string) {
+		if readErr != nil {
+			return
+		}
+		var dirFiles []ArgsBatchFile
+		dirFiles, readErr = ReadPackageFiles(context, dir)
+		files = append(files, dirFiles...)
+	})
+	if err == nil {
+		err = readErr
+	}
+	return files, err
+}
+
+// Visits directory tree, skips directories ignored by go tool: testdata,
+// vendor and ones starting with '.' or '_'
+func WalkPackageDirs(root string, visit func(dir string)) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if path != root && (name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+		visit(path)
+		return nil
+	})
+}
+
+// Path relative to working directory if file is inside it
+func GetDisplayPath(path string) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// Converts byte column into 1-based screen column with tab stops every
+// 8 columns, as required by GNU coding standards
+func GetScreenColumn(content []byte, pos GoPos) int {
+	lineStart := pos.Offset - pos.Column + 1
+	if lineStart < 0 || pos.Offset > len(content) {
+		return pos.Column
+	}
+	column := 0
+	for _, r := range string(content[lineStart:pos.Offset]) {
+		if r == '\t' {
+			column += 8 - column%8
+		} else {
+			column++
+		}
+	}
+	return column + 1
+}
+
+// Line-based formats need one line per error, details are joined
+func GetSingleLineMessage(message string) string {
+	message = strings.Replace(message, "\n\t", "; ", -1)
+	return strings.Replace(message, "\n", " ", -1)
+}
+
+// Default vim 'errorformat': file:line:col: message
+func FormatQuickfixErrors(writer io.Writer, report *ErrorReport) {
+	for _, fileError := range report.Errors {
+		fmt.Fprintf(writer, "%s:%d:%d: %s\n", fileError.Path, fileError.Line, fileError.Column, GetSingleLineMessage(fileError.Message))
+	}
+}
+
+// GCC diagnostics: file:line:col: error: message
+func FormatGccErrors(writer io.Writer, report *ErrorReport) {
+	for _, fileError := range report.Errors {
+		fmt.Fprintf(writer, "%s:%d:%d: error: %s\n", fileError.Path, fileError.Line, fileError.Column, GetSingleLineMessage(fileError.Message))
+	}
+}
+
+// GNU format with ranges understood by Emacs compilation mode:
+// file:line.col-endline.endcol: message, range end is inclusive
+func FormatEmacsErrors(writer io.Writer, report *ErrorReport) {
+	for _, fileError := range report.Errors {
+		span := fmt.Sprintf("%d.%d", fileError.Line, fileError.Column)
+		if fileError.End.Offset > fileError.Offset && fileError.End.Column > 1 {
+			if fileError.End.Line == fileError.Line {
+				span += fmt.Sprintf("-%d", fileError.End.Column-1)
+			} else {
+				span += fmt.Sprintf("-%d.%d", fileError.End.Line, fileError.End.Column-1)
+			}
+		}
+		fmt.Fprintf(writer, "%s:%s: error: %s\n", fileError.Path, span, GetSingleLineMessage(fileError.Message))
+	}
+}
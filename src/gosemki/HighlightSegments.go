@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"go/scanner"
+	"go/token"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Lexical classes of tokens not covered by semantic ranges
+const (
+	TokenClassNone = iota
+	TokenClassKeyword
+	TokenClassString
+	TokenClassNumber
+	TokenClassComment
+)
+
+// Piece of source with the same highlighting, used by renderers
+type HighlightSegment struct {
+	Start      int // byte offsets
+	End        int
+	Kind       GoKind // GoKindBad if not covered by range
+	TokenClass int
+	Errors     []*GoError
+	Anchor     string // outline item name like 'Type.Method' if segment starts declared name
+}
+
+// Splits content into segments by boundaries of tokens, ranges, errors and
+// outline names. Result positions must be in bytes. Errors of file without
+// tokens are kept in empty segment at the end.
+func BuildHighlightSegments(content []byte, result *IndexerResult) []HighlightSegment {
+	size := len(content)
+	kinds := make([]GoKind, size)
+	classes := make([]int, size)
+	boundaries := map[int]bool{0: true, size: true}
+	mark := func(start, end int) (int, int) {
+		start, end = ClampOffset(start, size), ClampOffset(end, size)
+		boundaries[start] = true
+		boundaries[end] = true
+		return start, end
+	}
+
+	var tokenScanner scanner.Scanner
+	fset := token.NewFileSet()
+	tokenScanner.Init(fset.AddFile("", -1, size), content, nil, scanner.ScanComments)
+	for {
+		pos, tok, lit := tokenScanner.Scan()
+		if tok == token.EOF {
+			break
+		}
+		class := TokenClassNone
+		switch {
+		case tok.IsKeyword():
+			class = TokenClassKeyword
+		case tok == token.STRING || tok == token.CHAR:
+			class = TokenClassString
+		case tok == token.INT || tok == token.FLOAT || tok == token.IMAG:
+			class = TokenClassNumber
+		case tok == token.COMMENT:
+			class = TokenClassComment
+		}
+		if class == TokenClassNone {
+			continue
+		}
+		length := len(lit)
+		if tok.IsKeyword() {
+			length = len(tok.String())
+		}
+		start, end := mark(int(pos)-1, int(pos)-1+length)
+		for i := start; i < end; i++ {
+			classes[i] = class
+		}
+	}
+	for _, goRange := range result.Ranges {
+		start, end := mark(goRange.Offset, goRange.Offset+goRange.Length)
+		for i := start; i < end; i++ {
+			kinds[i] = goRange.Kind
+		}
+	}
+	errorSpans := make([][2]int, len(result.Errors))
+	for i, goError := range result.Errors {
+		start, end := goError.Offset, goError.End.Offset
+		if start >= size {
+			// error at end of file, like missed '}', marks the last token
+			start, end = GetLastTokenSpan(content)
+			if start == end {
+				// file has no tokens, error gets marker segment
+				errorSpans[i][0], errorSpans[i][1] = -1, -1
+				continue
+			}
+		}
+		if end <= start {
+			// make error at whitespace or end of file visible
+			end = start + 1
+		}
+		errorSpans[i][0], errorSpans[i][1] = mark(start, end)
+	}
+	anchors := make(map[int]string)
+	var addAnchors func(outline []GoOutline, prefix string)
+	addAnchors = func(outline []GoOutline, prefix string) {
+		for _, item := range outline {
+			if len(item.Path) != 0 {
+				continue
+			}
+			start, _ := mark(item.Offset, item.End.Offset)
+			anchors[start] = prefix + item.Name
+			addAnchors(item.Children, prefix+item.Name+".")
+		}
+	}
+	addAnchors(result.Outline, "")
+
+	offsets := make([]int, 0, len(boundaries))
+	for offset := range boundaries {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+	segments := make([]HighlightSegment, 0, len(offsets))
+	for i := 0; i+1 < len(offsets); i++ {
+		segment := HighlightSegment{
+			Start:      offsets[i],
+			End:        offsets[i+1],
+			Kind:       kinds[offsets[i]],
+			TokenClass: classes[offsets[i]],
+			Anchor:     anchors[offsets[i]],
+		}
+		for j, span := range errorSpans {
+			if span[0] <= segment.Start && segment.Start < span[1] {
+				segment.Errors = append(segment.Errors, &result.Errors[j])
+			}
+		}
+		segments = append(segments, segment)
+	}
+	marker := HighlightSegment{Start: size, End: size}
+	for i, span := range errorSpans {
+		if span[0] < 0 {
+			marker.Errors = append(marker.Errors, &result.Errors[i])
+		}
+	}
+	if len(marker.Errors) != 0 {
+		segments = append(segments, marker)
+	}
+	return segments
+}
+
+// Span of the last rune which is not white space, empty if there is none
+func GetLastTokenSpan(content []byte) (int, int) {
+	end := len(bytes.TrimRightFunc(content, unicode.IsSpace))
+	_, size := utf8.DecodeLastRune(content[:end])
+	return end - size, end
+}
+
+func ClampOffset(offset, size int) int {
+	if offset < 0 {
+		return 0
+	}
+	if offset > size {
+		return size
+	}
+	return offset
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Errors at end of file, like missed '}', must not be lost by the export
+func TestHighlightSegmentsKeepErrorsAtEndOfFile(t *testing.T) {
+	for _, content := range []string{"package eof\n\nfunc f() {\n", "", "\n\n"} {
+		result := IndexTestFile(t, content)
+		if len(result.Errors) == 0 {
+			t.Errorf("%q: expected syntax error", content)
+			continue
+		}
+		var messages []string
+		for _, segment := range BuildHighlightSegments([]byte(content), &result) {
+			for _, goError := range segment.Errors {
+				messages = append(messages, goError.Message)
+			}
+		}
+		if !strings.Contains(strings.Join(messages, "\n"), "found 'EOF'") {
+			t.Errorf("%q: expected error at end of file in segments, got %q", content, messages)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/constant"
+	"go/doc/comment"
+	"go/printer"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Doc links like [io.Reader] point to the same site as `go doc`
+	HOVER_DOC_LINK_BASE_URL = "https://pkg.go.dev"
+	// Limits recursion of constants referring to other constants
+	CONST_EVAL_MAX_DEPTH = 32
+)
+
+// Collects signature, type and doc comment of identifier at byte offset
+// of the file sent by editor
+func (this *PackageIndexer) FindHover(filePath string, content []byte, offset int) (GoHover, error) {
+	ident, selector, err := this.FindIdentAt(filePath, content, offset)
+	if err != nil {
+		return GoHover{}, err
+	}
+	obj, fset := this.ResolveIdent(ident, selector)
+	if obj == nil {
+		return GoHover{}, errors.New(fmt.Sprintf("declaration of '%s' not found", ident.Name))
+	}
+	hover := GoHover{
+		GoLocation: MakeLocation(this.fset, ident),
+		Name:       obj.Name,
+		Kind:       inferObjectKind(obj),
+	}
+	files := this.GetPackageFiles(fset)
+	switch decl := obj.Decl.(type) {
+	case *ast.FuncDecl:
+		signature := *decl
+		signature.Doc = nil
+		signature.Body = nil
+		hover.Signature = FormatNode(fset, &signature)
+		hover.Type = FormatNode(fset, decl.Type)
+		hover.Doc = GetCommentText(decl.Doc)
+	case *ast.TypeSpec:
+		spec := *decl
+		spec.Doc = nil
+		spec.Comment = nil
+		hover.Signature = "type " + FormatNode(fset, &spec)
+		hover.Type = FormatNode(fset, decl.Type)
+		hover.Doc = GetSpecDoc(FindGenDecl(files, decl), decl.Doc, decl.Comment)
+	case *ast.ValueSpec:
+		genDecl := FindGenDecl(files, decl)
+		typeExpr, valueExpr := GetValueSpecExprs(genDecl, decl, obj.Name)
+		if obj.Kind == ast.Con {
+			this.DescribeConst(&hover, obj, fset, valueExpr)
+		} else {
+			hover.Signature = "var " + obj.Name
+			if typeExpr != nil {
+				hover.Type = FormatNode(fset, typeExpr)
+				hover.Signature += " " + hover.Type
+			} else if valueExpr != nil {
+				hover.Type = this.InferObjectType(obj, fset)
+				hover.Signature += " = " + FormatNode(fset, valueExpr)
+			}
+		}
+		hover.Doc = GetSpecDoc(genDecl, decl.Doc, decl.Comment)
+	case *ast.Field:
+		hover.Type = FormatNode(fset, decl.Type)
+		hover.Signature = "var " + obj.Name + " " + hover.Type
+		if obj.Kind == ast.Fun {
+			// method of interface
+			hover.Signature = "func " + obj.Name + strings.TrimPrefix(hover.Type, "func")
+		}
+		hover.Doc = GetSpecDoc(nil, decl.Doc, decl.Comment)
+	case *ast.AssignStmt:
+		hover.Signature = "var " + obj.Name
+		hover.Type = this.InferObjectType(obj, fset)
+		if len(hover.Type) != 0 {
+			hover.Signature += " " + hover.Type
+		}
+	case *ast.ImportSpec:
+		path, _ := strconv.Unquote(decl.Path.Value)
+		hover.Signature = fmt.Sprintf("package %s (%q)", obj.Name, path)
+		if pkg := this.imported[path]; pkg != nil {
+			hover.Doc = pkg.Doc
+		}
+	case *ast.LabeledStmt:
+		hover.Signature = "label " + obj.Name
+	default:
+		hover.Signature = obj.Name
+	}
+	hover.Markdown = RenderDocMarkdown(hover.Doc)
+	return hover, nil
+}
+
+// Constant gets value and type, untyped constants get kind of value.
+// Signature keeps declared expression, value is evaluated.
+func (this *PackageIndexer) DescribeConst(hover *GoHover, obj *ast.Object, fset *token.FileSet, valueExpr ast.Expr) {
+	evaluator := ConstEvaluator{indexer: this}
+	value, typeExpr := evaluator.EvalObject(obj, this.GetImportedByFileSet(fset))
+	if typeExpr != nil {
+		hover.Type = FormatNode(fset, typeExpr)
+	} else if value.Kind() != constant.Unknown {
+		hover.Type = "untyped " + GetConstKindName(value.Kind())
+	}
+	hover.Signature = "const " + obj.Name
+	if typeExpr != nil {
+		hover.Signature += " " + hover.Type
+	}
+	if value.Kind() != constant.Unknown {
+		hover.Value = value.String()
+	}
+	if valueExpr != nil {
+		hover.Signature += " = " + FormatNode(fset, valueExpr)
+	} else if len(hover.Value) != 0 {
+		hover.Signature += " = " + hover.Value
+	}
+}
+
+func GetConstKindName(kind constant.Kind) string {
+	switch kind {
+	case constant.Bool:
+		return "bool"
+	case constant.String:
+		return "string"
+	case constant.Int:
+		return "int"
+	case constant.Float:
+		return "float"
+	case constant.Complex:
+		return "complex"
+	}
+	return ""
+}
+
+// Returns files of indexed or imported package, depending on FileSet
+func (this *PackageIndexer) GetPackageFiles(fset *token.FileSet) map[string]*ast.File {
+	if pkg := this.GetImportedByFileSet(fset); pkg != nil {
+		return pkg.Files
+	}
+	return this.files
+}
+
+// Finds declaration containing type or value spec
+func FindGenDecl(files map[string]*ast.File, spec ast.Spec) *ast.GenDecl {
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			// specs of imported packages can lose all names filtered by
+			// ast.FileExports, so they have no positions
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, other := range genDecl.Specs {
+				if other == spec {
+					return genDecl
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns type and value of the name, constants without values repeat
+// previous spec of the group
+func GetValueSpecExprs(genDecl *ast.GenDecl, spec *ast.ValueSpec, name string) (ast.Expr, ast.Expr) {
+	index := 0
+	for i, ident := range spec.Names {
+		if ident.Name == name {
+			index = i
+		}
+	}
+	source := spec
+	if len(spec.Values) == 0 && spec.Type == nil && genDecl != nil && genDecl.Tok == token.CONST {
+		for _, other := range genDecl.Specs {
+			if other == spec {
+				break
+			}
+			if valueSpec := other.(*ast.ValueSpec); len(valueSpec.Values) != 0 {
+				source = valueSpec
+			}
+		}
+	}
+	var value ast.Expr
+	if index < len(source.Values) {
+		value = source.Values[index]
+	}
+	return source.Type, value
+}
+
+// Doc comment of spec, or of declaration if it's not a group, or line
+// comment
+func GetSpecDoc(genDecl *ast.GenDecl, doc *ast.CommentGroup, lineComment *ast.CommentGroup) string {
+	if doc == nil && genDecl != nil && !genDecl.Lparen.IsValid() {
+		doc = genDecl.Doc
+	}
+	if doc == nil {
+		doc = lineComment
+	}
+	return GetCommentText(doc)
+}
+
+func GetCommentText(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	return doc.Text()
+}
+
+func RenderDocMarkdown(doc string) string {
+	if len(doc) == 0 {
+		return ""
+	}
+	var parser comment.Parser
+	printer := comment.Printer{DocLinkBaseURL: HOVER_DOC_LINK_BASE_URL}
+	return string(printer.Markdown(parser.Parse(doc)))
+}
+
+// Prints node as gofmt does
+func FormatNode(fset *token.FileSet, node ast.Node) string {
+	var buffer bytes.Buffer
+	config := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := config.Fprint(&buffer, fset, node); err != nil {
+		return ""
+	}
+	return buffer.String()
+}
+
+// Empty if type is not inferred
+func (this *PackageIndexer) InferObjectType(obj *ast.Object, fset *token.FileSet) string {
+	inferrer := NewTypeInferrer(this)
+	if ref, ok := inferrer.InferObject(obj, this.GetImportedByFileSet(fset)); ok {
+		return inferrer.FormatType(ref)
+	}
+	return ""
+}
+
+// Evaluates constant declarations with go/constant. Value is unknown if
+// expression uses unsupported operations or constants of packages imported
+// by other package.
+type ConstEvaluator struct {
+	indexer *PackageIndexer
+	depth   int
+}
+
+// Returns value and explicit type of constant declared in imported
+// package, or in indexed package if pkg is nil
+func (this *ConstEvaluator) EvalObject(obj *ast.Object, pkg *CachedPackage) (value constant.Value, typeExpr ast.Expr) {
+	value = constant.MakeUnknown()
+	spec, ok := obj.Decl.(*ast.ValueSpec)
+	if !ok || this.depth > CONST_EVAL_MAX_DEPTH {
+		return
+	}
+	defer func() {
+		// go/constant panics on operands of mismatched kinds
+		if err := recover(); err != nil {
+			value = constant.MakeUnknown()
+		}
+	}()
+	files := this.indexer.files
+	if pkg != nil {
+		files = pkg.Files
+	}
+	typeExpr, valueExpr := GetValueSpecExprs(FindGenDecl(files, spec), spec, obj.Name)
+	if valueExpr == nil {
+		return
+	}
+	iota, _ := obj.Data.(int)
+	this.depth++
+	value = this.EvalExpr(valueExpr, iota, pkg)
+	this.depth--
+	if call, ok := valueExpr.(*ast.CallExpr); ok && typeExpr == nil && value.Kind() != constant.Unknown {
+		// typed by conversion, e.g. time.Duration(5)
+		typeExpr = call.Fun
+	}
+	return
+}
+
+func (this *ConstEvaluator) EvalExpr(expr ast.Expr, iota int, pkg *CachedPackage) constant.Value {
+	switch x := expr.(type) {
+	case *ast.BasicLit:
+		return constant.MakeFromLiteral(x.Value, x.Kind, 0)
+	case *ast.ParenExpr:
+		return this.EvalExpr(x.X, iota, pkg)
+	case *ast.Ident:
+		if x.Obj == nil || (pkg == nil && this.indexer.IsBuiltinObject(x.Obj)) {
+			switch x.Name {
+			case "iota":
+				return constant.MakeInt64(int64(iota))
+			case "true", "false":
+				return constant.MakeBool(x.Name == "true")
+			}
+			return constant.MakeUnknown()
+		}
+		if x.Obj.Kind == ast.Con {
+			value, _ := this.EvalObject(x.Obj, pkg)
+			return value
+		}
+	case *ast.SelectorExpr:
+		// package names are resolved only in indexed package
+		if selected := this.indexer.GetSelectedPackage(x); selected != nil && pkg == nil {
+			if obj := selected.Scope.Lookup(x.Sel.Name); obj != nil && obj.Kind == ast.Con {
+				value, _ := this.EvalObject(obj, selected)
+				return value
+			}
+		}
+	case *ast.UnaryExpr:
+		operand := this.EvalExpr(x.X, iota, pkg)
+		if operand.Kind() == constant.Unknown {
+			break
+		}
+		return constant.UnaryOp(x.Op, operand, 0)
+	case *ast.BinaryExpr:
+		left := this.EvalExpr(x.X, iota, pkg)
+		right := this.EvalExpr(x.Y, iota, pkg)
+		if left.Kind() == constant.Unknown || right.Kind() == constant.Unknown {
+			break
+		}
+		switch x.Op {
+		case token.SHL, token.SHR:
+			shift, ok := constant.Uint64Val(right)
+			if !ok {
+				break
+			}
+			return constant.Shift(left, x.Op, uint(shift))
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return constant.MakeBool(constant.Compare(left, x.Op, right))
+		case token.QUO:
+			if left.Kind() == constant.Int && right.Kind() == constant.Int {
+				// integer division
+				return constant.BinaryOp(left, token.QUO_ASSIGN, right)
+			}
+		}
+		return constant.BinaryOp(left, x.Op, right)
+	case *ast.CallExpr:
+		if len(x.Args) != 1 {
+			break
+		}
+		arg := this.EvalExpr(x.Args[0], iota, pkg)
+		if name, ok := x.Fun.(*ast.Ident); ok && name.Name == "len" {
+			if arg.Kind() != constant.String {
+				break
+			}
+			return constant.MakeInt64(int64(len(constant.StringVal(arg))))
+		}
+		// conversion keeps value
+		return arg
+	}
+	return constant.MakeUnknown()
+}
+
+// Object must be declared in indexed package, builtin declarations are
+// injected into it from string
+func (this *PackageIndexer) IsBuiltinObject(obj *ast.Object) bool {
+	decl, ok := obj.Decl.(ast.Node)
+	return ok && len(this.fset.Position(decl.Pos()).Filename) == 0
+}
+
+// Prints signature and doc of identifier at offset
+func (this *Client) ExecHover() int {
+	flagSet := flag.NewFlagSet("hover", flag.ExitOnError)
+	offset := flagSet.Int("offset", -1, "byte offset of identifier in file")
+	asJson := flagSet.Bool("json", false, "print hover information as JSON")
+	this.CommandArgs = ParseCommandFlags(flagSet, this.CommandArgs)
+	if *offset < 0 {
+		panic(errors.New("missed -offset=<offset> option"))
+	}
+	content, path := this.ReadEditorFile()
+	context := PackGoBuildContext(&build.Default)
+	reply := ClientFindHover(this.RpcClient, content, path, context, *offset)
+	if !reply.Found {
+		fmt.Fprintf(os.Stderr, "%s\n", reply.Reason)
+		return 1
+	}
+	if *asJson {
+		jsonBytes, err := json.Marshal(reply.Hover)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", string(jsonBytes))
+		return 0
+	}
+	fmt.Printf("%s\n", reply.Hover.Signature)
+	if len(reply.Hover.Doc) != 0 {
+		fmt.Printf("\n%s", reply.Hover.Doc)
+	}
+	return 0
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Signature of constant keeps declared expression, evaluated value goes
+// to separate field
+func TestHoverConstKeepsDeclaredExpression(t *testing.T) {
+	content := "package sample\n\nconst Answer = 6*7\n"
+	var result IndexerResult
+	indexer := NewPackageIndexer(&result)
+	indexer.context = build.Default
+	hover, err := indexer.FindHover(filepath.Join(t.TempDir(), "sample.go"), []byte(content), strings.Index(content, "Answer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hover.Signature != "const Answer = 6 * 7" || hover.Value != "42" {
+		t.Errorf("unexpected signature '%s' and value '%s'", hover.Signature, hover.Value)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"go/build"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Classes: k-<kind> for identifiers (see goKindToString), t-kw, t-str,
+// t-num and t-com for keywords, literals and comments, err for errors
+const HTML_DEFAULT_CSS = `body { background: #fdfdfd; color: #1f2328; }
+pre.gosemki { font: 13px/1.45 monospace; tab-size: 4; }
+nav.gosemki-outline { font: 13px sans-serif; }
+.t-kw { color: #a626a4; font-weight: bold; }
+.t-str { color: #50a14f; }
+.t-num { color: #986801; }
+.t-com { color: #8a8f98; font-style: italic; }
+.k-pkg { color: #0184bc; }
+.k-con { color: #986801; }
+.k-typ { color: #c18401; }
+.k-var { color: #1f2328; }
+.k-fld { color: #e45649; }
+.k-fun { color: #4078f2; }
+.k-lbl { color: #a626a4; }
+.err { text-decoration: underline wavy #e51400; cursor: help; }
+`
+
+var g_tokenClassCss = map[int]string{
+	TokenClassKeyword: "t-kw",
+	TokenClassString:  "t-str",
+	TokenClassNumber:  "t-num",
+	TokenClassComment: "t-com",
+}
+
+type HtmlExportOptions struct {
+	Title  string
+	Css    string // inlined into page
+	CssUrl string // linked instead of inline stylesheet if not empty
+}
+
+// Renders file to standalone HTML page
+func (this *Client) ExecHtml() int {
+	flagSet := flag.NewFlagSet("html", flag.ExitOnError)
+	cssPath := flagSet.String("css", "", "stylesheet file inlined instead of default one")
+	cssUrl := flagSet.String("css-url", "", "link stylesheet by URL instead of inlining it")
+	title := flagSet.String("title", "", "page title, file name by default")
+	this.CommandArgs = ParseCommandFlags(flagSet, this.CommandArgs)
+	content, path := this.ReadSourceFile()
+
+	options := HtmlExportOptions{Title: *title, Css: HTML_DEFAULT_CSS, CssUrl: *cssUrl}
+	if len(options.Title) == 0 {
+		options.Title = filepath.Base(path)
+	}
+	if len(*cssPath) != 0 {
+		css, err := ioutil.ReadFile(*cssPath)
+		if err != nil {
+			panic(err)
+		}
+		options.Css = string(css)
+	}
+	context := PackGoBuildContext(&build.Default)
+	result := ClientReindex(this.RpcClient, content, path, context, IndexerOptions{})
+	writer := bufio.NewWriter(os.Stdout)
+	WriteHtmlPage(writer, content, &result, &options)
+	writer.Flush()
+	return 0
+}
+
+// Reads file given by -in option or <path> argument from disk
+func (this *Client) ReadSourceFile() ([]byte, string) {
+	path := g_app.Input
+	if len(path) == 0 {
+		if len(this.CommandArgs) == 0 {
+			panic(errors.New("missed <path> parameter or -in=<path> option"))
+		}
+		path = this.CommandArgs[0]
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		panic(err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	return content, path
+}
+
+func WriteHtmlPage(writer io.Writer, content []byte, result *IndexerResult, options *HtmlExportOptions) {
+	fmt.Fprintf(writer, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", html.EscapeString(options.Title))
+	if len(options.CssUrl) != 0 {
+		fmt.Fprintf(writer, "<link rel=\"stylesheet\" href=\"%s\">\n", html.EscapeString(options.CssUrl))
+	} else {
+		// stylesheet can't contain closing tag, so it's not escaped
+		fmt.Fprintf(writer, "<style>\n%s</style>\n", options.Css)
+	}
+	io.WriteString(writer, "</head>\n<body>\n")
+
+	segments := BuildHighlightSegments(content, result)
+	anchors := GetHtmlAnchors(segments)
+	if len(result.Outline) != 0 {
+		io.WriteString(writer, "<nav class=\"gosemki-outline\"><ul>\n")
+		for _, segment := range segments {
+			if id := anchors[segment.Start]; len(id) != 0 {
+				fmt.Fprintf(writer, "<li><a href=\"#%s\">%s</a></li>\n", id, html.EscapeString(segment.Anchor))
+			}
+		}
+		io.WriteString(writer, "</ul></nav>\n")
+	}
+	io.WriteString(writer, "<pre class=\"gosemki\">")
+	for _, segment := range segments {
+		WriteHtmlSegment(writer, content, &segment, anchors[segment.Start])
+	}
+	io.WriteString(writer, "</pre>\n</body>\n</html>\n")
+}
+
+// Outline names can repeat (e.g. methods of different types), so ids get
+// numeric suffix
+func GetHtmlAnchors(segments []HighlightSegment) map[int]string {
+	anchors := make(map[int]string)
+	used := make(map[string]bool)
+	for _, segment := range segments {
+		if len(segment.Anchor) == 0 {
+			continue
+		}
+		id := segment.Anchor
+		for i := 2; used[id]; i++ {
+			id = fmt.Sprintf("%s-%d", segment.Anchor, i)
+		}
+		used[id] = true
+		anchors[segment.Start] = id
+	}
+	return anchors
+}
+
+func WriteHtmlSegment(writer io.Writer, content []byte, segment *HighlightSegment, anchor string) {
+	text := html.EscapeString(string(content[segment.Start:segment.End]))
+	if len(text) == 0 && len(segment.Errors) != 0 {
+		// marker of errors in file without tokens
+		text = " "
+	}
+	var classes []string
+	if segment.Kind != GoKindBad {
+		classes = append(classes, "k-"+goKindToString(segment.Kind))
+	} else if class, ok := g_tokenClassCss[segment.TokenClass]; ok {
+		classes = append(classes, class)
+	}
+	var messages []string
+	for _, goError := range segment.Errors {
+		messages = append(messages, goError.Message)
+	}
+	if len(messages) != 0 {
+		classes = append(classes, "err")
+	}
+	if len(classes) == 0 && len(anchor) == 0 {
+		io.WriteString(writer, text)
+		return
+	}
+	tag := "span"
+	if len(anchor) != 0 {
+		tag = "a"
+	}
+	fmt.Fprintf(writer, "<%s", tag)
+	if len(anchor) != 0 {
+		fmt.Fprintf(writer, " id=\"%s\" href=\"#%s\"", html.EscapeString(anchor), html.EscapeString(anchor))
+	}
+	if len(classes) != 0 {
+		fmt.Fprintf(writer, " class=\"%s\"", strings.Join(classes, " "))
+	}
+	if len(messages) != 0 {
+		fmt.Fprintf(writer, " title=\"%s\"", html.EscapeString(strings.Join(messages, "\n")))
+	}
+	fmt.Fprintf(writer, ">%s</%s>", text, tag)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const (
+	HTTP_MAX_REQUEST_SIZE = 64 * 1024 * 1024
+	// Browsers cache answer to preflight request for this many seconds
+	HTTP_CORS_MAX_AGE = "600"
+)
+
+// HTTP/JSON endpoints for browser-based tools. Request body has the same
+// fields as ServerRPC.Reindex arguments, response body is IndexerResult.
+//
+//	POST /highlight    whole result
+//	POST /outline      only outline
+//	POST /diagnostics  only errors
+//
+// With '?stream=1' query response is NDJSON of GoStreamChunk, one per phase.
+// Pages of any origin may call endpoints, since requests are authorized by
+// token in header rather than by cookies.
+type HttpHandler struct {
+	Server *Server
+	Token  string // required as 'Authorization: Bearer <token>' if not empty
+}
+
+type HttpError struct {
+	Error string `json:"error"`
+}
+
+func NewHttpHandler(server *Server, token string) http.Handler {
+	handler := &HttpHandler{Server: server, Token: token}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/highlight", func(w http.ResponseWriter, r *http.Request) {
+		handler.Reindex(w, r, func(result *IndexerResult) {})
+	})
+	mux.HandleFunc("/outline", func(w http.ResponseWriter, r *http.Request) {
+		handler.Reindex(w, r, func(result *IndexerResult) {
+			*result = IndexerResult{Outline: result.Outline, InPanic: result.InPanic}
+		}, SectionOutline)
+	})
+	mux.HandleFunc("/diagnostics", func(w http.ResponseWriter, r *http.Request) {
+		handler.Reindex(w, r, func(result *IndexerResult) {
+			*result = IndexerResult{Errors: result.Errors, InPanic: result.InPanic}
+		}, SectionErrors)
+	})
+	return mux
+}
+
+// Sections limit indexing for endpoints returning part of result
+func (this *HttpHandler) Reindex(w http.ResponseWriter, r *http.Request, filter func(result *IndexerResult), sections ...string) {
+	this.SetCorsHeaders(w)
+	if r.Method == http.MethodOptions {
+		// CORS preflight carries no token
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost+", "+http.MethodOptions)
+		this.WriteError(w, http.StatusMethodNotAllowed, "only POST requests are accepted")
+		return
+	}
+	if !this.IsAuthorized(r) {
+		this.WriteError(w, http.StatusUnauthorized, "missed or invalid auth token")
+		return
+	}
+	var args ArgsReindex
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, HTTP_MAX_REQUEST_SIZE))
+	if err := decoder.Decode(&args); err != nil {
+		this.WriteError(w, http.StatusBadRequest, "malformed request: "+err.Error())
+		return
+	}
+	if len(args.Path) == 0 {
+		this.WriteError(w, http.StatusBadRequest, "missed 'Path' field")
+		return
+	}
+	if len(sections) != 0 {
+		args.Options.Sections = sections
+	}
+	if !this.Server.BeginRequest() {
+		this.WriteError(w, http.StatusServiceUnavailable, ErrShuttingDown.Error())
+		return
+	}
+	if r.URL.Query().Get("stream") == "1" {
+		defer this.Server.EndRequest()
+		this.Stream(w, &args, filter)
+		return
+	}
+	var result IndexerResult
+	this.Server.Reindex(&args, &result)
+	this.Server.EndRequest()
+	filter(&result)
+	this.WriteJson(w, http.StatusOK, &result)
+}
+
+// Writes NDJSON chunk after each indexing phase
+func (this *HttpHandler) Stream(w http.ResponseWriter, args *ArgsReindex, filter func(result *IndexerResult)) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	this.Server.ReindexStream(args, func(chunk *GoStreamChunk) {
+		filter(chunk.Result)
+		encoder.Encode(chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+}
+
+func (this *HttpHandler) SetCorsHeaders(w http.ResponseWriter) {
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Access-Control-Allow-Methods", http.MethodPost+", "+http.MethodOptions)
+	header.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	header.Set("Access-Control-Max-Age", HTTP_CORS_MAX_AGE)
+}
+
+func (this *HttpHandler) IsAuthorized(r *http.Request) bool {
+	if len(this.Token) == 0 {
+		return true
+	}
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(this.Token)) == 1
+}
+
+func (this *HttpHandler) WriteError(w http.ResponseWriter, status int, message string) {
+	this.WriteJson(w, status, &HttpError{message})
+}
+
+func (this *HttpHandler) WriteJson(w http.ResponseWriter, status int, value interface{}) {
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		status = http.StatusInternalServerError
+		jsonBytes, _ = json.Marshal(&HttpError{err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonBytes)
+	w.Write([]byte("\n"))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const HTTP_TEST_TOKEN = "secret"
+
+const HTTP_TEST_SOURCE = `package sample
+
+func Answer() int {
+	return undefinedName
+}
+`
+
+func NewTestHttpHandler(t *testing.T) (http.Handler, string) {
+	path := filepath.Join(t.TempDir(), "sample.go")
+	if err := os.WriteFile(path, []byte(HTTP_TEST_SOURCE), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return NewHttpHandler(NewServer(), HTTP_TEST_TOKEN), path
+}
+
+func ServeTestRequest(handler http.Handler, method, url, token, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, url, strings.NewReader(body))
+	if len(token) != 0 {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func MakeTestRequestBody(t *testing.T, path string) string {
+	body, err := json.Marshal(&ArgsReindex{Path: path, Text: HTTP_TEST_SOURCE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestHttpHandlerRejectsBadToken(t *testing.T) {
+	handler, path := NewTestHttpHandler(t)
+	body := MakeTestRequestBody(t, path)
+	for _, token := range []string{"", "wrong"} {
+		recorder := ServeTestRequest(handler, http.MethodPost, "/highlight", token, body)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("token '%s': expected status 401, got %d", token, recorder.Code)
+		}
+	}
+}
+
+func TestHttpHandlerRejectsGet(t *testing.T) {
+	handler, _ := NewTestHttpHandler(t)
+	recorder := ServeTestRequest(handler, http.MethodGet, "/highlight", HTTP_TEST_TOKEN, "")
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405, got %d", recorder.Code)
+	}
+	if allow := recorder.Header().Get("Allow"); !strings.Contains(allow, http.MethodPost) {
+		t.Errorf("expected POST in Allow header, got '%s'", allow)
+	}
+}
+
+func TestHttpHandlerAnswersPreflight(t *testing.T) {
+	handler, _ := NewTestHttpHandler(t)
+	recorder := ServeTestRequest(handler, http.MethodOptions, "/outline", "", "")
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", recorder.Code)
+	}
+	if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("expected any origin allowed, got '%s'", origin)
+	}
+	if headers := recorder.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(headers, "Authorization") {
+		t.Errorf("expected Authorization in allowed headers, got '%s'", headers)
+	}
+}
+
+func TestHttpHandlerFiltersSections(t *testing.T) {
+	handler, path := NewTestHttpHandler(t)
+	body := MakeTestRequestBody(t, path)
+	results := make(map[string]IndexerResult)
+	for _, endpoint := range []string{"/highlight", "/outline", "/diagnostics"} {
+		recorder := ServeTestRequest(handler, http.MethodPost, endpoint, HTTP_TEST_TOKEN, body)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", endpoint, recorder.Code, recorder.Body.String())
+		}
+		var result IndexerResult
+		if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: %v", endpoint, err)
+		}
+		results[endpoint] = result
+	}
+	if highlight := results["/highlight"]; len(highlight.Ranges) == 0 || len(highlight.Outline) == 0 || len(highlight.Errors) == 0 {
+		t.Errorf("/highlight: expected all sections, got %+v", highlight)
+	}
+	if outline := results["/outline"]; len(outline.Outline) == 0 || len(outline.Ranges) != 0 || len(outline.Errors) != 0 {
+		t.Errorf("/outline: expected only outline, got %+v", outline)
+	}
+	if diagnostics := results["/diagnostics"]; len(diagnostics.Errors) == 0 || len(diagnostics.Ranges) != 0 || len(diagnostics.Outline) != 0 {
+		t.Errorf("/diagnostics: expected only errors, got %+v", diagnostics)
+	}
+}
+
+func TestHttpHandlerStreamsChunks(t *testing.T) {
+	handler, path := NewTestHttpHandler(t)
+	recorder := ServeTestRequest(handler, http.MethodPost, "/highlight?stream=1", HTTP_TEST_TOKEN, MakeTestRequestBody(t, path))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("expected NDJSON content type, got '%s'", contentType)
+	}
+	var chunks []GoStreamChunk
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		var chunk GoStreamChunk
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			t.Fatalf("malformed chunk '%s': %v", scanner.Text(), err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("expected chunk per phase, got %d chunks", len(chunks))
+	}
+	if chunks[0].Phase != PhaseParse {
+		t.Errorf("expected '%s' phase first, got '%s'", PhaseParse, chunks[0].Phase)
+	}
+	if last := chunks[len(chunks)-1]; !last.Done {
+		t.Errorf("expected last chunk to be done, got %+v", last)
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"go/ast"
-	"strconv"
+	"strings"
 )
 
+// Version of JSON output, incremented on incompatible changes,
+// see 'gosemki schema'
+const RESULT_SCHEMA_VERSION = 3
+
+// Kind of identifier, written to JSON as short string
+type GoKind int
+
 const (
-	GoKindBad = iota
+	GoKindBad GoKind = iota
 	GoKindPkg
 	GoKindConst
 	GoKindType
@@ -16,6 +23,7 @@ const (
 	GoKindField
 	GoKindFunc
 	GoKindLabel
+	GoKindKeyword // completion candidates only
 )
 
 func isAstObjectAField(obj *ast.Object) bool {
@@ -29,8 +37,12 @@ func isAstObjectAField(obj *ast.Object) bool {
 	return false
 }
 
-func inferIdentKind(ident *ast.Ident) int {
-	switch ident.Obj.Kind {
+func inferIdentKind(ident *ast.Ident) GoKind {
+	return inferObjectKind(ident.Obj)
+}
+
+func inferObjectKind(obj *ast.Object) GoKind {
+	switch obj.Kind {
 	case ast.Pkg:
 		return GoKindPkg
 	case ast.Con:
@@ -38,7 +50,7 @@ func inferIdentKind(ident *ast.Ident) int {
 	case ast.Typ:
 		return GoKindType
 	case ast.Var:
-		if isAstObjectAField(ident.Obj) {
+		if isAstObjectAField(obj) {
 			return GoKindField
 		}
 		return GoKindVar
@@ -50,146 +62,268 @@ func inferIdentKind(ident *ast.Ident) int {
 	return GoKindBad
 }
 
-func goKindToString(kind int) string {
+func goKindToString(kind GoKind) string {
 	switch kind {
-	case int(GoKindPkg):
+	case GoKindPkg:
 		return "pkg"
-	case int(GoKindConst):
+	case GoKindConst:
 		return "con"
-	case int(GoKindType):
+	case GoKindType:
 		return "typ"
-	case int(GoKindVar):
+	case GoKindVar:
 		return "var"
-	case int(GoKindField):
+	case GoKindField:
 		return "fld"
-	case int(GoKindFunc):
+	case GoKindFunc:
 		return "fun"
-	case int(GoKindLabel):
+	case GoKindLabel:
 		return "lbl"
+	case GoKindKeyword:
+		return "kwd"
 	}
 	return ""
 }
 
+func (this GoKind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(goKindToString(this))
+}
+
+func (this *GoKind) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	for kind := GoKindBad; kind <= GoKindKeyword; kind++ {
+		if goKindToString(kind) == str {
+			*this = kind
+			return nil
+		}
+	}
+	return errors.New("unknown identifier kind '" + str + "'")
+}
+
 type GoPos struct {
-	Line   int
-	Column int
-	Offset int
+	Line   int `json:"lin"`
+	Column int `json:"col"`
+	Offset int `json:"off"`
+}
+
+// Start and end (exclusive) of code fragment
+type GoSpan struct {
+	Start GoPos `json:"start"`
+	End   GoPos `json:"end"`
 }
 
 type GoRange struct {
 	GoPos
-	Length int
-	Kind   int // ast.ObjKind transformed to int
+	End    GoPos  `json:"end"`
+	Length int    `json:"len"`
+	Kind   GoKind `json:"knd"`
 }
 
+// Position and End are span of declared name, Decl is span of whole
+// declaration. Types contain fields and methods, const and var groups
+// contain declared names.
 type GoOutline struct {
 	GoPos
-	Name string
-	Kind int // ast.ObjKind transformed to int
+	End      GoPos       `json:"end"`
+	Decl     GoSpan      `json:"decl"`
+	Name     string      `json:"str"`
+	Kind     GoKind      `json:"knd"`
+	Detail   string      `json:"detail,omitempty"`   // signature or type
+	Path     string      `json:"path,omitempty"`     // set if item is declared in other package file
+	Children []GoOutline `json:"children,omitempty"` // nil for leaf items
+}
+
+// Span of identifier in any file
+type GoLocation struct {
+	GoPos
+	End  GoPos  `json:"end"`
+	Path string `json:"path"`
 }
 
+// Declaration of identifier found by definition query, location is span
+// of declared name
+type GoDefinition struct {
+	GoLocation
+	Decl GoSpan `json:"decl"`
+	Name string `json:"str"`
+	Kind GoKind `json:"knd"`
+}
+
+// Hover information about identifier, location is span of identifier
+type GoHover struct {
+	GoLocation
+	Name      string `json:"str"`
+	Kind      GoKind `json:"knd"`
+	Signature string `json:"signature"`          // declaration without body and doc
+	Type      string `json:"type,omitempty"`     // empty if not inferred
+	Doc       string `json:"doc,omitempty"`      // doc comment text
+	Markdown  string `json:"markdown,omitempty"` // doc comment rendered to markdown
+	Value     string `json:"value,omitempty"`    // evaluated value of constant
+}
+
+// Name which can be inserted at cursor, signature is type of variable,
+// field or constant, func type or underlying type of type
+type GoCandidate struct {
+	Name      string `json:"str"`
+	Kind      GoKind `json:"knd"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// Codes of errors, used as rule IDs by reports
+const (
+	ErrorCodeSyntax           = "syntax-error"
+	ErrorCodeUnresolvedIdent  = "unresolved-identifier"
+	ErrorCodeUnresolvedImport = "unresolved-import"
+	ErrorCodeRedeclaration    = "redeclaration"
+	ErrorCodePackageMismatch  = "package-mismatch"
+	ErrorCodeSemantic         = "semantic-error"
+)
+
 type GoError struct {
 	GoPos
-	Length  int
-	Message string
+	End     GoPos  `json:"end"`
+	Length  int    `json:"len"`
+	Message string `json:"msg"`
+	Code    string `json:"code"`
+}
+
+// Detects code of error reported by go/ast package resolver
+func ClassifySemanticError(message string) string {
+	switch {
+	case strings.HasPrefix(message, "undeclared name:"):
+		return ErrorCodeUnresolvedIdent
+	case strings.HasPrefix(message, "could not import"):
+		return ErrorCodeUnresolvedImport
+	case strings.Contains(message, "redeclared in this block"):
+		return ErrorCodeRedeclaration
+	case strings.HasPrefix(message, "package ") && strings.Contains(message, "; expected "):
+		return ErrorCodePackageMismatch
+	}
+	return ErrorCodeSemantic
 }
 
 type GoFoldScope struct {
-	LineFrom int
-	LineTo   int
+	LineFrom int `json:"from"`
+	LineTo   int `json:"to"`
+}
+
+// Indexing phases, results of each phase can be streamed to client
+const (
+	PhaseParse     = "parse"     // syntax errors
+	PhaseStructure = "structure" // folds and outline
+	PhaseRanges    = "ranges"    // highlighted identifiers
+	PhaseSemantic  = "semantic"  // resolution errors
+)
+
+// Partial result of single phase, last chunk of stream has Done flag
+type GoStreamChunk struct {
+	Phase  string         `json:"phase,omitempty"`
+	Result *IndexerResult `json:"result"`
+	Done   bool           `json:"done,omitempty"`
+}
+
+// Range of byte offsets in the indexed file, usually visible part of editor
+type GoViewport struct {
+	FromOffset int
+	ToOffset   int
+}
+
+func (this *GoViewport) Intersects(fromOffset, toOffset int) bool {
+	return fromOffset <= this.ToOffset && toOffset >= this.FromOffset
+}
+
+// Sections of IndexerResult which can be requested separately
+const (
+	SectionRanges  = "ranges"
+	SectionOutline = "outline"
+	SectionErrors  = "errors"
+	SectionFolds   = "folds"
+)
+
+var g_allSections = []string{SectionRanges, SectionOutline, SectionErrors, SectionFolds}
+
+// Per-request indexer settings
+type IndexerOptions struct {
+	Viewport *GoViewport // nil means whole file
+	Sections []string    // nil means all sections
+	Encoding string      // units of columns, offsets and lengths, bytes if empty
+	// Also report redeclarations inside of file and imports which are not
+	// found, used by errors command. Editors don't get them by default,
+	// since imports of modules outside of GOPATH are not found.
+	StrictErrors bool
+}
+
+func (this *IndexerOptions) HasSection(section string) bool {
+	if this.Sections == nil {
+		return true
+	}
+	for _, name := range this.Sections {
+		if name == section {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses comma-separated list of sections, e.g. "ranges,errors"
+func ParseSections(list string) ([]string, error) {
+	var sections []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		known := false
+		for _, section := range g_allSections {
+			known = known || section == name
+		}
+		if !known {
+			return nil, errors.New("unknown section '" + name + "', expected one of " + strings.Join(g_allSections, ","))
+		}
+		sections = append(sections, name)
+	}
+	return sections, nil
 }
 
 type IndexerResult struct {
-	Ranges  []GoRange
-	Errors  []GoError
-	Folds   []GoFoldScope
-	Outline []GoOutline
-	InPanic bool
-}
-
-func (this *GoRange) MarshalJSON() ([]byte, error) {
-	var jsonBytes bytes.Buffer
-	jsonBytes.WriteString("{\"lin\":")
-	jsonBytes.WriteString(strconv.Itoa(this.Line))
-	jsonBytes.WriteString(",\"col\":")
-	jsonBytes.WriteString(strconv.Itoa(this.Column))
-	jsonBytes.WriteString(",\"off\":")
-	jsonBytes.WriteString(strconv.Itoa(this.Offset))
-	jsonBytes.WriteString(",\"len\":")
-	jsonBytes.WriteString(strconv.Itoa(this.Length))
-	jsonBytes.WriteString(",\"knd\":\"")
-	jsonBytes.WriteString(goKindToString(this.Kind))
-	jsonBytes.WriteString("\"}")
-	return jsonBytes.Bytes(), nil
-}
-
-func (this *GoOutline) MarshalJSON() ([]byte, error) {
-	// TODO: escape this.Name quotes
-	var jsonBytes bytes.Buffer
-	jsonBytes.WriteString("{\"lin\":")
-	jsonBytes.WriteString(strconv.Itoa(this.Line))
-	jsonBytes.WriteString(",\"col\":")
-	jsonBytes.WriteString(strconv.Itoa(this.Column))
-	jsonBytes.WriteString(",\"off\":")
-	jsonBytes.WriteString(strconv.Itoa(this.Offset))
-	jsonBytes.WriteString(",\"str\":\"")
-	jsonBytes.WriteString(this.Name)
-	jsonBytes.WriteString("\",\"knd\":\"")
-	jsonBytes.WriteString(goKindToString(this.Kind))
-	jsonBytes.WriteString("\"}")
-	return jsonBytes.Bytes(), nil
-}
-
-func (this *GoError) MarshalJSON() ([]byte, error) {
-	var jsonBytes bytes.Buffer
-	jsonBytes.WriteString("{\"lin\":")
-	jsonBytes.WriteString(strconv.Itoa(this.Line))
-	jsonBytes.WriteString(",\"col\":")
-	jsonBytes.WriteString(strconv.Itoa(this.Column))
-	jsonBytes.WriteString(",\"off\":")
-	jsonBytes.WriteString(strconv.Itoa(this.Offset))
-	jsonBytes.WriteString(",\"len\":")
-	jsonBytes.WriteString(strconv.Itoa(this.Length))
-	jsonBytes.WriteString(",\"msg\":\"")
-	// TODO: escape this.Message quotes
-	jsonBytes.WriteString(this.Message)
-	jsonBytes.WriteString("\"}")
-	return jsonBytes.Bytes(), nil
-}
-
-func (this *GoFoldScope) MarshalJSON() ([]byte, error) {
-	var jsonBytes bytes.Buffer
-	jsonBytes.WriteString("{\"from\":")
-	jsonBytes.WriteString(strconv.Itoa(this.LineFrom))
-	jsonBytes.WriteString(",\"to\":")
-	jsonBytes.WriteString(strconv.Itoa(this.LineTo))
-	jsonBytes.WriteString("}")
-	return jsonBytes.Bytes(), nil
-}
-
-func (this *IndexerResult) MarshalJSON() ([]byte, error) {
-	rangesStr, _ := json.Marshal(this.Ranges)
-	errorsStr, _ := json.Marshal(this.Errors)
-	foldsStr, _ := json.Marshal(this.Folds)
-	outlineStr, _ := json.Marshal(this.Outline)
-
-	var jsonBytes bytes.Buffer
-	jsonBytes.WriteString("{\"ranges\":")
-	jsonBytes.Write(rangesStr)
-	jsonBytes.WriteString("{\"outline\":")
-	jsonBytes.Write(outlineStr)
-	jsonBytes.WriteString(",\"errors\":")
-	jsonBytes.Write(errorsStr)
-	jsonBytes.WriteString(",\"folds\":")
-	jsonBytes.Write(foldsStr)
-	jsonBytes.WriteString(",\"in_panic\":")
-	if this.InPanic {
-		jsonBytes.WriteString("true")
-	} else {
-		jsonBytes.WriteString("false")
+	Ranges  []GoRange     `json:"ranges"`
+	Outline []GoOutline   `json:"outline"`
+	Errors  []GoError     `json:"errors"`
+	Folds   []GoFoldScope `json:"folds"`
+	InPanic bool          `json:"in_panic"`
+}
+
+// Merges chunk of streamed result
+func (this *IndexerResult) Append(chunk *IndexerResult) {
+	this.Ranges = append(this.Ranges, chunk.Ranges...)
+	this.Errors = append(this.Errors, chunk.Errors...)
+	this.Folds = append(this.Folds, chunk.Folds...)
+	this.Outline = append(this.Outline, chunk.Outline...)
+	this.InPanic = this.InPanic || chunk.InPanic
+}
+
+// Nil sections are written as empty arrays, so all keys always present
+func (this IndexerResult) MarshalJSON() ([]byte, error) {
+	type plainResult IndexerResult
+	versioned := struct {
+		Version int `json:"version"`
+		plainResult
+	}{RESULT_SCHEMA_VERSION, plainResult(this)}
+	if versioned.Ranges == nil {
+		versioned.Ranges = []GoRange{}
+	}
+	if versioned.Outline == nil {
+		versioned.Outline = []GoOutline{}
+	}
+	if versioned.Errors == nil {
+		versioned.Errors = []GoError{}
+	}
+	if versioned.Folds == nil {
+		versioned.Folds = []GoFoldScope{}
 	}
-	jsonBytes.WriteString("\"}")
-	return jsonBytes.Bytes(), nil
+	return json.Marshal(&versioned)
 }
 
 func (this *IndexerResult) AddRange(goRange GoRange) {
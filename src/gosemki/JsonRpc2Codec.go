@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+//-------------------------------------------------------------------------
+// JSON-RPC 2.0 codec for net/rpc server
+//
+// Requests and responses are JSON objects separated by newlines. Method
+// can be given with or without "ServerRPC." prefix, params can be either
+// object with arguments or array with single such object. Malformed line
+// gets Parse error response, reading continues from the next line.
+//-------------------------------------------------------------------------
+
+const (
+	JSONRPC_SERVICE_PREFIX   = "ServerRPC."
+	JSONRPC_PARSE_ERROR      = -32700
+	JSONRPC_INVALID_REQUEST  = -32600
+	JSONRPC_METHOD_NOT_FOUND = -32601
+	JSONRPC_INVALID_PARAMS   = -32602
+	JSONRPC_SERVER_ERROR     = -32000
+)
+
+type jsonRpc2Request struct {
+	JsonRpc string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id"`
+	Method  string           `json:"method"`
+	Params  *json.RawMessage `json:"params"`
+}
+
+type jsonRpc2Response struct {
+	JsonRpc string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *jsonRpc2Error   `json:"error,omitempty"`
+}
+
+type jsonRpc2Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type JsonRpc2ServerCodec struct {
+	conn    io.ReadWriteCloser
+	reader  *bufio.Reader
+	encoder *json.Encoder
+	request jsonRpc2Request
+
+	mutex   sync.Mutex
+	seq     uint64
+	pending map[uint64]*json.RawMessage // nil id means notification
+	invalid map[uint64]*jsonRpc2Error   // requests rejected before method call
+}
+
+func NewJsonRpc2ServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	return &JsonRpc2ServerCodec{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		encoder: json.NewEncoder(conn),
+		pending: make(map[uint64]*json.RawMessage),
+		invalid: make(map[uint64]*jsonRpc2Error),
+	}
+}
+
+func (this *JsonRpc2ServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	this.request = jsonRpc2Request{}
+	line, err := this.ReadLine()
+	if err != nil {
+		return err
+	}
+	var invalid *jsonRpc2Error
+	if err = json.Unmarshal(line, &this.request); err != nil {
+		// id of malformed request is unknown, response gets null id
+		this.request = jsonRpc2Request{}
+		if _, isSyntax := err.(*json.SyntaxError); isSyntax {
+			invalid = &jsonRpc2Error{JSONRPC_PARSE_ERROR, "Parse error: " + err.Error()}
+		} else {
+			invalid = &jsonRpc2Error{JSONRPC_INVALID_REQUEST, err.Error()}
+		}
+	} else if this.request.JsonRpc != "2.0" {
+		invalid = &jsonRpc2Error{JSONRPC_INVALID_REQUEST, "expected \"jsonrpc\": \"2.0\""}
+	}
+	method := this.request.Method
+	if !strings.Contains(method, ".") {
+		method = JSONRPC_SERVICE_PREFIX + method
+	}
+
+	this.mutex.Lock()
+	this.seq++
+	this.pending[this.seq] = this.request.Id
+	if invalid != nil {
+		this.invalid[this.seq] = invalid
+		// unknown method makes net/rpc reply with error without calling anything
+		method = JSONRPC_SERVICE_PREFIX
+	}
+	r.Seq = this.seq
+	this.mutex.Unlock()
+
+	r.ServiceMethod = method
+	return nil
+}
+
+// Returns next non-empty line, io.EOF after the last one
+func (this *JsonRpc2ServerCodec) ReadLine() ([]byte, error) {
+	for {
+		line, err := this.reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) != 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (this *JsonRpc2ServerCodec) ReadRequestBody(x interface{}) error {
+	if x == nil || this.request.Params == nil {
+		return nil
+	}
+	params := bytes.TrimSpace(*this.request.Params)
+	if len(params) > 0 && params[0] == '[' {
+		var array []json.RawMessage
+		if err := json.Unmarshal(params, &array); err != nil {
+			return err
+		}
+		if len(array) != 1 {
+			return errors.New("params array must contain exactly one object")
+		}
+		params = array[0]
+	}
+	return json.Unmarshal(params, x)
+}
+
+func (this *JsonRpc2ServerCodec) WriteResponse(r *rpc.Response, x interface{}) error {
+	this.mutex.Lock()
+	id, found := this.pending[r.Seq]
+	invalidError, invalid := this.invalid[r.Seq]
+	delete(this.pending, r.Seq)
+	delete(this.invalid, r.Seq)
+	this.mutex.Unlock()
+
+	if !found {
+		return errors.New("invalid sequence number in response")
+	}
+	if id == nil && !invalid {
+		// notifications don't get responses
+		return nil
+	}
+	response := jsonRpc2Response{JsonRpc: "2.0", Id: id}
+	if invalid {
+		response.Error = invalidError
+	} else if len(r.Error) != 0 {
+		response.Error = &jsonRpc2Error{jsonRpc2ErrorCode(r.Error), r.Error}
+	} else {
+		response.Result = x
+	}
+	if id == nil {
+		null := json.RawMessage("null")
+		response.Id = &null
+	}
+	return this.encoder.Encode(response)
+}
+
+func (this *JsonRpc2ServerCodec) Close() error {
+	return this.conn.Close()
+}
+
+// Maps net/rpc error text to JSON-RPC error code
+func jsonRpc2ErrorCode(message string) int {
+	switch {
+	case strings.HasPrefix(message, "rpc: can't find"):
+		return JSONRPC_METHOD_NOT_FOUND
+	case strings.HasPrefix(message, "rpc: service/method request ill-formed"):
+		return JSONRPC_METHOD_NOT_FOUND
+	case strings.HasPrefix(message, "json:"):
+		return JSONRPC_INVALID_PARAMS
+	}
+	return JSONRPC_SERVER_ERROR
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/rpc"
+	"testing"
+)
+
+type TestEchoRPC struct{}
+
+func (this *TestEchoRPC) Echo(args *ArgsReindex, reply *ArgsReindex) error {
+	*reply = *args
+	return nil
+}
+
+// Malformed line gets Parse error with null id, next request on the same
+// connection is still served
+func TestJsonRpc2ParseErrorKeepsConnection(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("ServerRPC", new(TestEchoRPC)); err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeCodec(NewJsonRpc2ServerCodec(serverConn))
+
+	go clientConn.Write([]byte("{\"jsonrpc\": \"2.0\", \"id\": 1, \"method\": \n" +
+		"{\"jsonrpc\": \"2.0\", \"id\": 2, \"method\": \"Echo\", \"params\": {\"Path\": \"a.go\"}}\n"))
+	reader := bufio.NewReader(clientConn)
+	var responses []map[string]interface{}
+	for len(responses) < 2 {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response map[string]interface{}
+		if err = json.Unmarshal(line, &response); err != nil {
+			t.Fatalf("malformed response '%s': %v", line, err)
+		}
+		responses = append(responses, response)
+	}
+	parseError, ok := responses[0]["error"].(map[string]interface{})
+	if !ok || parseError["code"] != float64(JSONRPC_PARSE_ERROR) || responses[0]["id"] != nil {
+		t.Errorf("expected parse error with null id, got %v", responses[0])
+	}
+	if result, ok := responses[1]["result"].(map[string]interface{}); !ok || result["Path"] != "a.go" || responses[1]["id"] != float64(2) {
+		t.Errorf("expected echo of the next request, got %v", responses[1])
+	}
+}
@@ -26,16 +26,6 @@ func (this *KeyValueExprVisitor) InspectNode(node ast.Node) bool {
 
 func (this *KeyValueExprVisitor) ApplyIdent() {
 	if this.funName != nil {
-		pos := this.indexer.fset.Position(this.funName.NamePos)
-		goRange := GoRange{
-			GoPos: GoPos{
-				Line:   pos.Line,
-				Column: pos.Column,
-				Offset: pos.Offset,
-			},
-			Length: len(this.funName.Name),
-			Kind:   GoKindField,
-		}
-		this.indexer.result.AddRange(goRange)
+		this.indexer.result.AddRange(this.indexer.MakeRange(this.funName, GoKindField))
 	}
 }
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//-------------------------------------------------------------------------
+// Subset of Language Server Protocol used by `gosemki lsp`
+//-------------------------------------------------------------------------
+
+const (
+	LSP_PARSE_ERROR      = -32700
+	LSP_INVALID_REQUEST  = -32600
+	LSP_METHOD_NOT_FOUND = -32601
+	LSP_INVALID_PARAMS   = -32602
+	LSP_INTERNAL_ERROR   = -32603
+)
+
+const (
+	LSP_SYMBOL_KIND_FUNCTION = 12
+	LSP_SYMBOL_KIND_CLASS    = 5
+	LSP_SYMBOL_KIND_METHOD   = 6
+	LSP_SYMBOL_KIND_FIELD    = 8
+	LSP_SYMBOL_KIND_VARIABLE = 13
+	LSP_SYMBOL_KIND_CONSTANT = 14
+	LSP_SEVERITY_ERROR       = 1
+	LSP_SYNC_FULL            = 1
+)
+
+// Legend of semantic tokens, indexes are used in encoded token data
+var LspTokenTypes = []string{"namespace", "variable", "type", "property", "function", "label"}
+var LspTokenModifiers = []string{"readonly"}
+
+type LspMessage struct {
+	JsonRpc string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type LspResponse struct {
+	JsonRpc string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type LspErrorResponse struct {
+	JsonRpc string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id"`
+	Error   LspError         `json:"error"`
+}
+
+type LspNotification struct {
+	JsonRpc string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type LspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (this *LspError) Error() string {
+	return this.Message
+}
+
+type LspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type LspRange struct {
+	Start LspPosition `json:"start"`
+	End   LspPosition `json:"end"`
+}
+
+type LspTextDocumentIdentifier struct {
+	Uri string `json:"uri"`
+}
+
+type LspInitializeParams struct {
+	Capabilities struct {
+		General struct {
+			PositionEncodings []string `json:"positionEncodings"`
+		} `json:"general"`
+	} `json:"capabilities"`
+}
+
+type LspDidOpenParams struct {
+	TextDocument struct {
+		Uri     string `json:"uri"`
+		Version int    `json:"version"`
+		Text    string `json:"text"`
+	} `json:"textDocument"`
+}
+
+type LspDidChangeParams struct {
+	TextDocument struct {
+		Uri     string `json:"uri"`
+		Version int    `json:"version"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Range *LspRange `json:"range"`
+		Text  string    `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type LspTextDocumentParams struct {
+	TextDocument LspTextDocumentIdentifier `json:"textDocument"`
+}
+
+type LspSemanticTokensDeltaParams struct {
+	TextDocument     LspTextDocumentIdentifier `json:"textDocument"`
+	PreviousResultId string                    `json:"previousResultId"`
+}
+
+type LspSemanticTokensRangeParams struct {
+	TextDocument LspTextDocumentIdentifier `json:"textDocument"`
+	Range        LspRange                  `json:"range"`
+}
+
+type LspSemanticTokens struct {
+	ResultId string `json:"resultId,omitempty"`
+	Data     []int  `json:"data"`
+}
+
+type LspSemanticTokensEdit struct {
+	Start       int   `json:"start"`
+	DeleteCount int   `json:"deleteCount"`
+	Data        []int `json:"data"`
+}
+
+type LspSemanticTokensDelta struct {
+	ResultId string                  `json:"resultId"`
+	Edits    []LspSemanticTokensEdit `json:"edits"`
+}
+
+type LspDocumentSymbol struct {
+	Name           string              `json:"name"`
+	Detail         string              `json:"detail,omitempty"`
+	Kind           int                 `json:"kind"`
+	Range          LspRange            `json:"range"`
+	SelectionRange LspRange            `json:"selectionRange"`
+	Children       []LspDocumentSymbol `json:"children,omitempty"`
+}
+
+type LspFoldingRange struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine"`
+}
+
+type LspDiagnostic struct {
+	Range    LspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type LspPublishDiagnosticsParams struct {
+	Uri         string          `json:"uri"`
+	Version     int             `json:"version"`
+	Diagnostics []LspDiagnostic `json:"diagnostics"`
+}
+
+// Reads one message framed with Content-Length header
+func ReadLspMessage(reader *bufio.Reader) ([]byte, error) {
+	contentLength := -1
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			break
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return nil, errors.New(fmt.Sprintf("malformed LSP header '%s'", line))
+		}
+		if strings.EqualFold(strings.TrimSpace(line[:colon]), "Content-Length") {
+			contentLength, err = strconv.Atoi(strings.TrimSpace(line[colon+1:]))
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("malformed Content-Length '%s'", line))
+			}
+		}
+	}
+	if contentLength < 0 {
+		return nil, errors.New("missed Content-Length header")
+	}
+	content := make([]byte, contentLength)
+	_, err := io.ReadFull(reader, content)
+	return content, err
+}
+
+func WriteLspMessage(writer io.Writer, message interface{}) error {
+	content, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(writer, "Content-Length: %d\r\n\r\n", len(content)); err != nil {
+		return err
+	}
+	_, err = writer.Write(content)
+	return err
+}
+
+func LspUriToPath(uri string) (string, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "file" {
+		return "", errors.New(fmt.Sprintf("unsupported document URI scheme '%s'", parsed.Scheme))
+	}
+	return filepath.FromSlash(parsed.Path), nil
+}
+
+func LspPathToUri(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func goKindToLspTokenType(kind GoKind) (tokenType int, modifiers int) {
+	switch kind {
+	case GoKindPkg:
+		return 0, 0
+	case GoKindConst:
+		return 1, 1
+	case GoKindVar:
+		return 1, 0
+	case GoKindType:
+		return 2, 0
+	case GoKindField:
+		return 3, 0
+	case GoKindFunc:
+		return 4, 0
+	case GoKindLabel:
+		return 5, 0
+	}
+	return -1, 0
+}
+
+// Functions nested into types are methods
+func goKindToLspSymbolKind(kind GoKind, parentKind GoKind) int {
+	switch kind {
+	case GoKindType:
+		return LSP_SYMBOL_KIND_CLASS
+	case GoKindField:
+		return LSP_SYMBOL_KIND_FIELD
+	case GoKindVar:
+		return LSP_SYMBOL_KIND_VARIABLE
+	case GoKindConst:
+		return LSP_SYMBOL_KIND_CONSTANT
+	}
+	if parentKind == GoKindType {
+		return LSP_SYMBOL_KIND_METHOD
+	}
+	return LSP_SYMBOL_KIND_FUNCTION
+}
+
+// Maps byte-based GoPos to LSP positions measured in UTF-8 bytes
+// or UTF-16 code units, depending on negotiated position encoding
+type LspPositionMapper struct {
+	encoder *PositionEncoder
+}
+
+func NewLspPositionMapper(content []byte, utf16 bool) *LspPositionMapper {
+	encoding := EncodingBytes
+	if utf16 {
+		encoding = EncodingUtf16
+	}
+	return &LspPositionMapper{encoder: NewPositionEncoder(content, encoding)}
+}
+
+// Converts 1-based line and byte column into LSP position
+func (this *LspPositionMapper) Position(line, column int) LspPosition {
+	if line < 1 || line > len(this.encoder.lineStarts) {
+		return LspPosition{Line: line - 1, Character: column - 1}
+	}
+	start := this.encoder.lineStarts[line-1]
+	return LspPosition{Line: line - 1, Character: this.encoder.CountUnits(start, start+column-1)}
+}
+
+// Converts LSP position into byte offset
+func (this *LspPositionMapper) Offset(pos LspPosition) int {
+	return this.encoder.DecodeInLine(pos.Line, pos.Character)
+}
+
+// Length of line in bytes without line break
+func (this *LspPositionMapper) LineLength(line int) int {
+	lineStarts := this.encoder.lineStarts
+	if line < 1 || line > len(lineStarts) {
+		return 0
+	}
+	start := lineStarts[line-1]
+	end := len(this.encoder.content)
+	if line < len(lineStarts) {
+		end = lineStarts[line] - 1
+	}
+	return end - start
+}
+
+// Span of single-line element starting at given position, clamped to line end
+func (this *LspPositionMapper) Range(pos GoPos, length int) LspRange {
+	endColumn := pos.Column + length
+	if maxColumn := this.LineLength(pos.Line) + 1; endColumn > maxColumn {
+		endColumn = maxColumn
+	}
+	return LspRange{
+		Start: this.Position(pos.Line, pos.Column),
+		End:   this.Position(pos.Line, endColumn),
+	}
+}
+
+// Span between two positions, can cross lines
+func (this *LspPositionMapper) Span(start GoPos, end GoPos) LspRange {
+	return LspRange{
+		Start: this.Position(start.Line, start.Column),
+		End:   this.Position(end.Line, end.Column),
+	}
+}
+
+// Encodes ranges as LSP semantic tokens: each token is 5 integers
+// deltaLine, deltaStart, length, tokenType, tokenModifiers
+func EncodeLspSemanticTokens(ranges []GoRange, mapper *LspPositionMapper) []int {
+	sorted := make([]GoRange, len(ranges))
+	copy(sorted, ranges)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Offset < sorted[j].Offset
+	})
+	data := make([]int, 0, 5*len(sorted))
+	prevLine, prevStart, prevEnd := 0, 0, -1
+	for _, goRange := range sorted {
+		tokenType, modifiers := goKindToLspTokenType(goRange.Kind)
+		if tokenType < 0 || goRange.Length <= 0 || goRange.Offset < prevEnd {
+			continue
+		}
+		span := mapper.Range(goRange.GoPos, goRange.Length)
+		if span.End.Character <= span.Start.Character {
+			continue
+		}
+		deltaStart := span.Start.Character
+		if span.Start.Line == prevLine {
+			deltaStart -= prevStart
+		}
+		data = append(data, span.Start.Line-prevLine, deltaStart,
+			span.End.Character-span.Start.Character, tokenType, modifiers)
+		prevLine, prevStart = span.Start.Line, span.Start.Character
+		prevEnd = goRange.Offset + goRange.Length
+	}
+	return data
+}
+
+// Describes new tokens as single edit replacing the middle part of previous
+// tokens. Common prefix and suffix are aligned to whole 5-integer tokens.
+func DiffLspSemanticTokens(previous, next []int) []LspSemanticTokensEdit {
+	prefix := 0
+	for prefix < len(previous) && prefix < len(next) && previous[prefix] == next[prefix] {
+		prefix++
+	}
+	prefix -= prefix % 5
+	suffix := 0
+	for suffix < len(previous)-prefix && suffix < len(next)-prefix &&
+		previous[len(previous)-1-suffix] == next[len(next)-1-suffix] {
+		suffix++
+	}
+	suffix -= suffix % 5
+	if prefix == len(previous) && prefix == len(next) {
+		return []LspSemanticTokensEdit{}
+	}
+	return []LspSemanticTokensEdit{{
+		Start:       prefix,
+		DeleteCount: len(previous) - prefix - suffix,
+		Data:        next[prefix : len(next)-suffix],
+	}}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"runtime/debug"
+	"strconv"
+)
+
+// Document opened in editor, content is kept in sync with editor buffer
+type LspDocument struct {
+	Uri     string
+	Path    string
+	Version int
+	Content []byte
+	Result  IndexerResult
+	// semantic tokens sent in last full or delta response
+	TokensResultId string
+	Tokens         []int
+}
+
+// Language server working on stdin/stdout. Uses daemon connection of the
+// client when available and falls back to in-process indexing otherwise.
+type LspServer struct {
+	Client     *Client
+	Context    GoBuildContext
+	Utf16      bool
+	cache      *PackageCache
+	writer     io.Writer
+	documents  map[string]*LspDocument
+	isShutdown bool
+	// last semantic tokens result id, unique across documents
+	lastResultId int
+}
+
+func NewLspServer(client *Client) *LspServer {
+	ret := new(LspServer)
+	ret.Client = client
+	ret.Context = PackGoBuildContext(&build.Default)
+	ret.Utf16 = true
+	ret.documents = make(map[string]*LspDocument)
+	return ret
+}
+
+func (this *Client) ExecLsp() int {
+	if err := this.Connect(); err != nil {
+		fmt.Fprintf(os.Stderr, "gosemki lsp: %s, indexing in process\n", err.Error())
+		this.RpcClient = nil
+	}
+	if this.RpcClient != nil {
+		defer this.RpcClient.Close()
+	}
+	server := NewLspServer(this)
+	return server.Run(os.Stdin, os.Stdout)
+}
+
+// Serves messages until 'exit' notification or end of input
+func (this *LspServer) Run(input io.Reader, output io.Writer) int {
+	reader := bufio.NewReader(input)
+	this.writer = output
+	for {
+		content, err := ReadLspMessage(reader)
+		if err == io.EOF {
+			return 1
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gosemki lsp: %s\n", err.Error())
+			return 1
+		}
+		var message LspMessage
+		if err = json.Unmarshal(content, &message); err != nil {
+			this.ReplyError(nil, &LspError{LSP_PARSE_ERROR, err.Error()})
+			continue
+		}
+		if message.Method == "exit" {
+			if this.isShutdown {
+				return 0
+			}
+			return 1
+		}
+		this.Dispatch(&message)
+	}
+}
+
+func (this *LspServer) Dispatch(message *LspMessage) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			fmt.Fprintf(os.Stderr, "gosemki lsp: panic: %v\n%s\n", panicErr, debug.Stack())
+			if message.Id != nil {
+				this.ReplyError(message.Id, &LspError{LSP_INTERNAL_ERROR, fmt.Sprintf("%v", panicErr)})
+			}
+		}
+	}()
+	result, err := this.Handle(message)
+	if message.Id == nil {
+		// notifications have no response
+		if err != nil && err.Code != LSP_METHOD_NOT_FOUND {
+			fmt.Fprintf(os.Stderr, "gosemki lsp: %s: %s\n", message.Method, err.Message)
+		}
+		return
+	}
+	if err != nil {
+		this.ReplyError(message.Id, err)
+		return
+	}
+	this.Write(LspResponse{JsonRpc: "2.0", Id: message.Id, Result: result})
+}
+
+func (this *LspServer) Handle(message *LspMessage) (interface{}, *LspError) {
+	if this.isShutdown && message.Id != nil {
+		return nil, &LspError{LSP_INVALID_REQUEST, "server is shut down"}
+	}
+	switch message.Method {
+	case "initialize":
+		var params LspInitializeParams
+		if err := this.ParseParams(message, &params); err != nil {
+			return nil, err
+		}
+		return this.Initialize(&params), nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		this.isShutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params LspDidOpenParams
+		if err := this.ParseParams(message, &params); err != nil {
+			return nil, err
+		}
+		return nil, this.DidOpen(&params)
+	case "textDocument/didChange":
+		var params LspDidChangeParams
+		if err := this.ParseParams(message, &params); err != nil {
+			return nil, err
+		}
+		return nil, this.DidChange(&params)
+	case "textDocument/didClose":
+		var params LspTextDocumentParams
+		if err := this.ParseParams(message, &params); err != nil {
+			return nil, err
+		}
+		return nil, this.DidClose(&params)
+	case "textDocument/semanticTokens/full":
+		return this.WithDocument(message, this.SemanticTokensFull)
+	case "textDocument/semanticTokens/full/delta":
+		return this.SemanticTokensDelta(message)
+	case "textDocument/semanticTokens/range":
+		return this.SemanticTokensRange(message)
+	case "textDocument/documentSymbol":
+		return this.WithDocument(message, this.DocumentSymbols)
+	case "textDocument/foldingRange":
+		return this.WithDocument(message, this.FoldingRanges)
+	}
+	return nil, &LspError{LSP_METHOD_NOT_FOUND, "method not supported: " + message.Method}
+}
+
+func (this *LspServer) ParseParams(message *LspMessage, params interface{}) *LspError {
+	if err := json.Unmarshal(message.Params, params); err != nil {
+		return &LspError{LSP_INVALID_PARAMS, err.Error()}
+	}
+	return nil
+}
+
+// Finds opened document referenced by request params and passes it to handler
+func (this *LspServer) WithDocument(message *LspMessage, handler func(doc *LspDocument) interface{}) (interface{}, *LspError) {
+	var params LspTextDocumentParams
+	if err := this.ParseParams(message, &params); err != nil {
+		return nil, err
+	}
+	doc := this.documents[params.TextDocument.Uri]
+	if doc == nil {
+		return nil, &LspError{LSP_INVALID_PARAMS, "document is not opened: " + params.TextDocument.Uri}
+	}
+	return handler(doc), nil
+}
+
+func (this *LspServer) Initialize(params *LspInitializeParams) interface{} {
+	encoding := "utf-16"
+	for _, supported := range params.Capabilities.General.PositionEncodings {
+		if supported == "utf-8" {
+			encoding = supported
+			this.Utf16 = false
+			break
+		}
+	}
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"positionEncoding": encoding,
+			"textDocumentSync": map[string]interface{}{
+				"openClose": true,
+				"change":    LSP_SYNC_FULL,
+			},
+			"semanticTokensProvider": map[string]interface{}{
+				"legend": map[string]interface{}{
+					"tokenTypes":     LspTokenTypes,
+					"tokenModifiers": LspTokenModifiers,
+				},
+				"full": map[string]interface{}{
+					"delta": true,
+				},
+				"range": true,
+			},
+			"documentSymbolProvider": true,
+			"foldingRangeProvider":   true,
+		},
+		"serverInfo": map[string]interface{}{
+			"name": "gosemki",
+		},
+	}
+}
+
+func (this *LspServer) DidOpen(params *LspDidOpenParams) *LspError {
+	path, err := LspUriToPath(params.TextDocument.Uri)
+	if err != nil {
+		return &LspError{LSP_INVALID_PARAMS, err.Error()}
+	}
+	doc := &LspDocument{
+		Uri:     params.TextDocument.Uri,
+		Path:    path,
+		Version: params.TextDocument.Version,
+		Content: []byte(params.TextDocument.Text),
+	}
+	this.documents[doc.Uri] = doc
+	this.Reindex(doc)
+	return nil
+}
+
+func (this *LspServer) DidChange(params *LspDidChangeParams) *LspError {
+	doc := this.documents[params.TextDocument.Uri]
+	if doc == nil {
+		return &LspError{LSP_INVALID_PARAMS, "document is not opened: " + params.TextDocument.Uri}
+	}
+	for _, change := range params.ContentChanges {
+		if change.Range == nil {
+			doc.Content = []byte(change.Text)
+			continue
+		}
+		mapper := NewLspPositionMapper(doc.Content, this.Utf16)
+		start := mapper.Offset(change.Range.Start)
+		end := mapper.Offset(change.Range.End)
+		content := make([]byte, 0, len(doc.Content)-(end-start)+len(change.Text))
+		content = append(content, doc.Content[:start]...)
+		content = append(content, change.Text...)
+		content = append(content, doc.Content[end:]...)
+		doc.Content = content
+	}
+	doc.Version = params.TextDocument.Version
+	this.Reindex(doc)
+	return nil
+}
+
+func (this *LspServer) DidClose(params *LspTextDocumentParams) *LspError {
+	doc := this.documents[params.TextDocument.Uri]
+	if doc == nil {
+		return nil
+	}
+	delete(this.documents, doc.Uri)
+	this.Notify("textDocument/publishDiagnostics", LspPublishDiagnosticsParams{
+		Uri:         doc.Uri,
+		Version:     doc.Version,
+		Diagnostics: []LspDiagnostic{},
+	})
+	return nil
+}
+
+// Indexes whole document, then publishes diagnostics
+func (this *LspServer) Reindex(doc *LspDocument) {
+	doc.Result = this.Index(doc, IndexerOptions{})
+	this.PublishDiagnostics(doc)
+}
+
+// Indexes document with daemon or in process if daemon is not available
+func (this *LspServer) Index(doc *LspDocument, options IndexerOptions) IndexerResult {
+	if this.Client.RpcClient != nil {
+		result, err := CallReindex(this.Client.RpcClient, doc.Content, doc.Path, this.Context, options)
+		if err == nil {
+			return result
+		}
+		fmt.Fprintf(os.Stderr, "gosemki lsp: daemon request failed: %s, indexing in process\n", err.Error())
+		this.Client.RpcClient.Close()
+		this.Client.RpcClient = nil
+	}
+	return this.IndexInProcess(doc, options)
+}
+
+func (this *LspServer) IndexInProcess(doc *LspDocument, options IndexerOptions) (result IndexerResult) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Fprintf(os.Stderr, "gosemki lsp: indexer panic: %v\n%s\n", err, debug.Stack())
+			result.InPanic = true
+			this.cache = nil
+			NewCrashReport(err, this.Context, options, map[string][]byte{doc.Path: doc.Content}).SaveAndPrint()
+		}
+	}()
+	if this.cache == nil {
+		this.cache = NewPackageCache(0)
+	}
+	indexer := new(PackageIndexer)
+	indexer.context = UnpackGoBuildContext(&this.Context)
+	indexer.cache = this.cache
+	indexer.options = options
+	indexer.result = &result
+	indexer.Reindex(doc.Path, doc.Content)
+	return
+}
+
+func (this *LspServer) PublishDiagnostics(doc *LspDocument) {
+	mapper := NewLspPositionMapper(doc.Content, this.Utf16)
+	diagnostics := make([]LspDiagnostic, 0, len(doc.Result.Errors))
+	for _, goError := range doc.Result.Errors {
+		diagnostics = append(diagnostics, LspDiagnostic{
+			Range:    mapper.Span(goError.GoPos, goError.End),
+			Severity: LSP_SEVERITY_ERROR,
+			Source:   "gosemki",
+			Message:  goError.Message,
+		})
+	}
+	this.Notify("textDocument/publishDiagnostics", LspPublishDiagnosticsParams{
+		Uri:         doc.Uri,
+		Version:     doc.Version,
+		Diagnostics: diagnostics,
+	})
+}
+
+// Encodes tokens and remembers them, so next request can get only delta
+func (this *LspServer) SemanticTokensFull(doc *LspDocument) interface{} {
+	mapper := NewLspPositionMapper(doc.Content, this.Utf16)
+	this.lastResultId++
+	doc.TokensResultId = strconv.Itoa(this.lastResultId)
+	doc.Tokens = EncodeLspSemanticTokens(doc.Result.Ranges, mapper)
+	return LspSemanticTokens{ResultId: doc.TokensResultId, Data: doc.Tokens}
+}
+
+// Returns edits against previous result if client still has it, full tokens otherwise
+func (this *LspServer) SemanticTokensDelta(message *LspMessage) (interface{}, *LspError) {
+	var params LspSemanticTokensDeltaParams
+	if err := this.ParseParams(message, &params); err != nil {
+		return nil, err
+	}
+	doc := this.documents[params.TextDocument.Uri]
+	if doc == nil {
+		return nil, &LspError{LSP_INVALID_PARAMS, "document is not opened: " + params.TextDocument.Uri}
+	}
+	previous := doc.Tokens
+	if doc.TokensResultId == "" || doc.TokensResultId != params.PreviousResultId {
+		return this.SemanticTokensFull(doc), nil
+	}
+	this.SemanticTokensFull(doc)
+	return LspSemanticTokensDelta{
+		ResultId: doc.TokensResultId,
+		Edits:    DiffLspSemanticTokens(previous, doc.Tokens),
+	}, nil
+}
+
+// Indexes only nodes visible in requested range
+func (this *LspServer) SemanticTokensRange(message *LspMessage) (interface{}, *LspError) {
+	var params LspSemanticTokensRangeParams
+	if err := this.ParseParams(message, &params); err != nil {
+		return nil, err
+	}
+	doc := this.documents[params.TextDocument.Uri]
+	if doc == nil {
+		return nil, &LspError{LSP_INVALID_PARAMS, "document is not opened: " + params.TextDocument.Uri}
+	}
+	mapper := NewLspPositionMapper(doc.Content, this.Utf16)
+	viewport := &GoViewport{
+		FromOffset: mapper.Offset(params.Range.Start),
+		ToOffset:   mapper.Offset(params.Range.End),
+	}
+	result := this.Index(doc, IndexerOptions{Viewport: viewport})
+	ranges := make([]GoRange, 0, len(result.Ranges))
+	for _, goRange := range result.Ranges {
+		if viewport.Intersects(goRange.Offset, goRange.Offset+goRange.Length) {
+			ranges = append(ranges, goRange)
+		}
+	}
+	return LspSemanticTokens{Data: EncodeLspSemanticTokens(ranges, mapper)}, nil
+}
+
+func (this *LspServer) DocumentSymbols(doc *LspDocument) interface{} {
+	mapper := NewLspPositionMapper(doc.Content, this.Utf16)
+	return MakeLspDocumentSymbols(doc.Result.Outline, GoKindBad, mapper)
+}
+
+// Skips items declared in other files, e.g. methods of type
+func MakeLspDocumentSymbols(outline []GoOutline, parentKind GoKind, mapper *LspPositionMapper) []LspDocumentSymbol {
+	symbols := make([]LspDocumentSymbol, 0, len(outline))
+	for _, item := range outline {
+		if len(item.Path) != 0 {
+			continue
+		}
+		symbols = append(symbols, LspDocumentSymbol{
+			Name:           item.Name,
+			Detail:         item.Detail,
+			Kind:           goKindToLspSymbolKind(item.Kind, parentKind),
+			Range:          mapper.Span(item.Decl.Start, item.Decl.End),
+			SelectionRange: mapper.Span(item.GoPos, item.End),
+			Children:       MakeLspDocumentSymbols(item.Children, item.Kind, mapper),
+		})
+	}
+	return symbols
+}
+
+func (this *LspServer) FoldingRanges(doc *LspDocument) interface{} {
+	folds := make([]LspFoldingRange, 0, len(doc.Result.Folds))
+	for _, fold := range doc.Result.Folds {
+		if fold.LineTo > fold.LineFrom {
+			folds = append(folds, LspFoldingRange{StartLine: fold.LineFrom - 1, EndLine: fold.LineTo - 1})
+		}
+	}
+	return folds
+}
+
+func (this *LspServer) ReplyError(id *json.RawMessage, err *LspError) {
+	this.Write(LspErrorResponse{JsonRpc: "2.0", Id: id, Error: *err})
+}
+
+func (this *LspServer) Notify(method string, params interface{}) {
+	this.Write(LspNotification{JsonRpc: "2.0", Method: method, Params: params})
+}
+
+func (this *LspServer) Write(message interface{}) {
+	if err := WriteLspMessage(this.writer, message); err != nil {
+		fmt.Fprintf(os.Stderr, "gosemki lsp: failed to write message: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/printer"
+	"go/token"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const OUTLINE_MAX_DETAIL_LENGTH = 120
+
+// Method declaration found in one of package files
+type outlineMethod struct {
+	path string
+	decl *ast.FuncDecl
+}
+
+// Builds outline tree of the file: types contain fields and methods from
+// all package files, methods of types declared in other files stay at top
+// level, const and var groups contain their names
+func (this *PackageIndexer) BuildOutline(filePath string) []GoOutline {
+	file := this.files[filePath]
+	methods := this.CollectMethods()
+	var outline []GoOutline
+	for _, decl := range file.Decls {
+		switch x := decl.(type) {
+		case *ast.FuncDecl:
+			typeName := GetReceiverTypeName(x)
+			if len(typeName) != 0 && this.IsTypeDeclaredInFile(file, typeName) {
+				continue
+			}
+			outline = append(outline, this.MakeFuncOutline(x, ""))
+		case *ast.GenDecl:
+			outline = append(outline, this.MakeGenDeclOutline(x, methods, filePath)...)
+		}
+	}
+	return outline
+}
+
+// Methods of all package files by receiver type name, in files order
+func (this *PackageIndexer) CollectMethods() map[string][]outlineMethod {
+	paths := make([]string, 0, len(this.files))
+	for path := range this.files {
+		if len(path) != 0 {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	methods := make(map[string][]outlineMethod)
+	for _, path := range paths {
+		for _, decl := range this.files[path].Decls {
+			if funcDecl, ok := decl.(*ast.FuncDecl); ok {
+				if typeName := GetReceiverTypeName(funcDecl); len(typeName) != 0 {
+					methods[typeName] = append(methods[typeName], outlineMethod{path, funcDecl})
+				}
+			}
+		}
+	}
+	return methods
+}
+
+func (this *PackageIndexer) IsTypeDeclaredInFile(file *ast.File, typeName string) bool {
+	for _, decl := range file.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.TYPE {
+			for _, spec := range genDecl.Specs {
+				if spec.(*ast.TypeSpec).Name.Name == typeName {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Name of receiver base type, empty for functions
+func GetReceiverTypeName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return ""
+	}
+	expr := decl.Recv.List[0].Type
+	for {
+		switch x := expr.(type) {
+		case *ast.StarExpr:
+			expr = x.X
+		case *ast.ParenExpr:
+			expr = x.X
+		case *ast.IndexExpr:
+			expr = x.X
+		case *ast.IndexListExpr:
+			// generic type with several type parameters
+			expr = x.X
+		case *ast.Ident:
+			return x.Name
+		default:
+			return ""
+		}
+	}
+}
+
+func (this *PackageIndexer) MakeFuncOutline(decl *ast.FuncDecl, path string) GoOutline {
+	item := this.MakeOutline(decl.Name, decl, GoKindFunc)
+	item.Detail = this.FormatDetail(decl.Type)
+	if typeName := GetReceiverTypeName(decl); len(typeName) != 0 {
+		item.Detail = "(" + this.FormatDetail(decl.Recv.List[0].Type) + ") " + item.Detail
+	}
+	item.Path = path
+	return item
+}
+
+func (this *PackageIndexer) MakeGenDeclOutline(decl *ast.GenDecl, methods map[string][]outlineMethod, filePath string) []GoOutline {
+	var items []GoOutline
+	for _, spec := range decl.Specs {
+		switch x := spec.(type) {
+		case *ast.TypeSpec:
+			var declNode ast.Node = x
+			if !decl.Lparen.IsValid() {
+				// single type declaration spans from 'type' keyword
+				declNode = decl
+			}
+			item := this.MakeOutline(x.Name, declNode, GoKindType)
+			item.Detail = this.FormatTypeDetail(x)
+			item.Children = this.MakeTypeMembersOutline(x.Type)
+			for _, method := range methods[x.Name.Name] {
+				path := ""
+				if method.path != filePath {
+					path = method.path
+				}
+				item.Children = append(item.Children, this.MakeFuncOutline(method.decl, path))
+			}
+			items = append(items, item)
+		case *ast.ValueSpec:
+			kind := GoKindVar
+			if decl.Tok == token.CONST {
+				kind = GoKindConst
+			}
+			for i, name := range x.Names {
+				if name.Name == "_" {
+					continue
+				}
+				var declNode ast.Node = x
+				if !decl.Lparen.IsValid() {
+					declNode = decl
+				}
+				item := this.MakeOutline(name, declNode, kind)
+				if x.Type != nil {
+					item.Detail = this.FormatDetail(x.Type)
+				} else if i < len(x.Values) {
+					item.Detail = "= " + this.FormatDetail(x.Values[i])
+				}
+				items = append(items, item)
+			}
+		}
+	}
+	if decl.Lparen.IsValid() && (decl.Tok == token.CONST || decl.Tok == token.VAR) {
+		// group item named by keyword contains declared names
+		group := GoOutline{
+			GoPos: this.MakeGoPos(decl.TokPos),
+			End:   this.MakeGoPos(decl.TokPos + token.Pos(len(decl.Tok.String()))),
+			Decl: GoSpan{
+				Start: this.MakeGoPos(decl.Pos()),
+				End:   this.MakeGoPos(decl.End()),
+			},
+			Name:     decl.Tok.String(),
+			Kind:     GoKindVar,
+			Children: items,
+		}
+		if decl.Tok == token.CONST {
+			group.Kind = GoKindConst
+		}
+		return []GoOutline{group}
+	}
+	return items
+}
+
+// Fields of struct or methods of interface
+func (this *PackageIndexer) MakeTypeMembersOutline(typeExpr ast.Expr) []GoOutline {
+	var fields *ast.FieldList
+	isInterface := false
+	switch x := typeExpr.(type) {
+	case *ast.StructType:
+		fields = x.Fields
+	case *ast.InterfaceType:
+		fields = x.Methods
+		isInterface = true
+	}
+	if fields == nil {
+		return nil
+	}
+	var items []GoOutline
+	for _, field := range fields.List {
+		kind := GoKindField
+		if isInterface {
+			kind = GoKindFunc
+		}
+		if len(field.Names) == 0 {
+			// embedded field or interface
+			name := GetEmbeddedName(field.Type)
+			if name == nil {
+				continue
+			}
+			if isInterface {
+				kind = GoKindType
+			}
+			item := this.MakeOutline(name, field, kind)
+			item.Detail = this.FormatDetail(field.Type)
+			items = append(items, item)
+			continue
+		}
+		for _, name := range field.Names {
+			item := this.MakeOutline(name, field, kind)
+			item.Detail = this.FormatDetail(field.Type)
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func GetEmbeddedName(expr ast.Expr) *ast.Ident {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return x
+	case *ast.StarExpr:
+		return GetEmbeddedName(x.X)
+	case *ast.SelectorExpr:
+		return x.Sel
+	case *ast.IndexExpr:
+		return GetEmbeddedName(x.X)
+	case *ast.IndexListExpr:
+		return GetEmbeddedName(x.X)
+	}
+	return nil
+}
+
+// Composite types are shortened to keyword, members are children
+func (this *PackageIndexer) FormatTypeDetail(spec *ast.TypeSpec) string {
+	detail := ""
+	switch spec.Type.(type) {
+	case *ast.StructType:
+		detail = "struct"
+	case *ast.InterfaceType:
+		detail = "interface"
+	default:
+		detail = this.FormatDetail(spec.Type)
+	}
+	if spec.Assign.IsValid() {
+		detail = "= " + detail
+	}
+	return detail
+}
+
+// Prints node as single line, long details are cut
+func (this *PackageIndexer) FormatDetail(node ast.Node) string {
+	var buffer bytes.Buffer
+	if err := printer.Fprint(&buffer, this.fset, node); err != nil {
+		return ""
+	}
+	detail := strings.Join(strings.Fields(buffer.String()), " ")
+	if len(detail) > OUTLINE_MAX_DETAIL_LENGTH {
+		cut := OUTLINE_MAX_DETAIL_LENGTH - 3
+		// don't split multibyte rune
+		for cut > 0 && !utf8.RuneStart(detail[cut]) {
+			cut--
+		}
+		detail = detail[:cut] + "..."
+	}
+	return detail
+}
+
+// Prints outline tree of the file as indented text or JSON
+func (this *Client) ExecOutline() int {
+	flagSet := flag.NewFlagSet("outline", flag.ExitOnError)
+	asJson := flagSet.Bool("json", false, "print outline items as JSON")
+	this.CommandArgs = ParseCommandFlags(flagSet, this.CommandArgs)
+	content, path := this.ReadSourceFile()
+	context := PackGoBuildContext(&build.Default)
+	options := IndexerOptions{Sections: []string{SectionOutline}}
+	result := ClientReindex(this.RpcClient, content, path, context, options)
+	if *asJson {
+		jsonBytes, err := json.Marshal(result.Outline)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", string(jsonBytes))
+		return 0
+	}
+	PrintOutline(os.Stdout, result.Outline, GoKindBad, "")
+	return 0
+}
+
+func PrintOutline(writer io.Writer, outline []GoOutline, parentKind GoKind, indent string) {
+	for _, item := range outline {
+		location := fmt.Sprintf("%d:%d", item.Line, item.Column)
+		if len(item.Path) != 0 {
+			location = GetDisplayPath(item.Path) + ":" + location
+		}
+		kind := GetOutlineKindName(item.Kind, parentKind)
+		text := strings.TrimSpace(strings.Join([]string{kind, item.Name, item.Detail}, " "))
+		if item.Name == kind {
+			// const and var groups
+			text = strings.TrimSpace(strings.Join([]string{kind, item.Detail}, " "))
+		}
+		fmt.Fprintf(writer, "%s%s  %s\n", indent, text, location)
+		PrintOutline(writer, item.Children, item.Kind, indent+"  ")
+	}
+}
+
+func GetOutlineKindName(kind GoKind, parentKind GoKind) string {
+	switch kind {
+	case GoKindType:
+		return "type"
+	case GoKindField:
+		return "field"
+	case GoKindConst:
+		return "const"
+	case GoKindVar:
+		return "var"
+	case GoKindFunc:
+		if parentKind == GoKindType {
+			return "method"
+		}
+		return "func"
+	}
+	return goKindToString(kind)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Methods of generic types are nested under their types whatever number
+// of type parameters
+func TestOutlineNestsMethodsOfGenericTypes(t *testing.T) {
+	result := IndexTestFile(t, `package sample
+
+type List[T any] []T
+
+func (l List[T]) Len() int { return len(l) }
+
+type Map[K comparable, V any] map[K]V
+
+func (m *Map[K, V]) Get(key K) V { return (*m)[key] }
+`)
+	if len(result.Outline) != 2 {
+		t.Fatalf("expected only types at top level, got %+v", result.Outline)
+	}
+	for _, item := range result.Outline {
+		if len(item.Children) != 1 {
+			t.Errorf("%s: expected method nested, got %+v", item.Name, item.Children)
+		}
+	}
+}
+
+// Long details are cut at rune boundary
+func TestOutlineCutsLongDetail(t *testing.T) {
+	result := IndexTestFile(t, "package sample\n\nvar Greeting = \"a"+strings.Repeat("привет ", 40)+"\"\n")
+	if len(result.Outline) != 1 {
+		t.Fatalf("expected single item, got %+v", result.Outline)
+	}
+	if detail := result.Outline[0].Detail; !utf8.ValidString(detail) || !strings.HasSuffix(detail, "...") {
+		t.Errorf("expected valid UTF-8 cut detail, got %q", detail)
+	}
+}
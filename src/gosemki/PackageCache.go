@@ -0,0 +1,201 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_MAX_CACHED_PACKAGES = 256
+)
+
+// Parsed imported package. Each package has own FileSet,
+// so cached AST can be shared between concurrent requests.
+type CachedPackage struct {
+	Dir        string
+	ImportPath string
+	Fset       *token.FileSet
+	Files      map[string]*ast.File
+	Scope      *ast.Scope
+	Doc        string // package doc comment, its file can have no exports
+	ModTimes   map[string]time.Time
+	LastUsed   time.Time
+}
+
+// Entry of cache saved on daemon shutdown. ASTs share ast.Object graphs
+// and are not saved, restarted daemon parses package again if its files
+// didn't change.
+type SavedPackage struct {
+	Dir        string
+	ImportPath string
+	ModTimes   map[string]time.Time
+	LastUsed   time.Time
+}
+
+// Cache of imported packages keyed by package directory
+type PackageCache struct {
+	MaxPackages int
+	mutex       sync.Mutex
+	packages    map[string]*CachedPackage
+}
+
+func NewPackageCache(maxPackages int) *PackageCache {
+	if maxPackages <= 0 {
+		maxPackages = DEFAULT_MAX_CACHED_PACKAGES
+	}
+	ret := new(PackageCache)
+	ret.MaxPackages = maxPackages
+	ret.packages = make(map[string]*CachedPackage)
+	return ret
+}
+
+func (this *PackageCache) Import(context *build.Context, path string, srcDir string) (*CachedPackage, error) {
+	pkgInfo, err := context.Import(path, srcDir, build.AllowBinary)
+	if err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	cached := this.packages[pkgInfo.Dir]
+	this.mutex.Unlock()
+	if cached != nil && cached.IsUpToDate(pkgInfo) {
+		this.mutex.Lock()
+		cached.LastUsed = time.Now()
+		this.mutex.Unlock()
+		return cached, nil
+	}
+
+	cached, err = ParseCachedPackage(pkgInfo)
+	if err != nil {
+		return nil, err
+	}
+	this.mutex.Lock()
+	this.packages[pkgInfo.Dir] = cached
+	this.EvictLocked()
+	this.mutex.Unlock()
+	return cached, nil
+}
+
+func (this *PackageCache) Len() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return len(this.packages)
+}
+
+func (this *PackageCache) Clear() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.packages = make(map[string]*CachedPackage)
+}
+
+func (this *PackageCache) Save() []SavedPackage {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	ret := make([]SavedPackage, 0, len(this.packages))
+	for _, cached := range this.packages {
+		ret = append(ret, SavedPackage{
+			Dir:        cached.Dir,
+			ImportPath: cached.ImportPath,
+			ModTimes:   cached.ModTimes,
+			LastUsed:   cached.LastUsed,
+		})
+	}
+	return ret
+}
+
+// Parses saved packages which are still up to date, packages imported
+// meanwhile are kept
+func (this *PackageCache) Restore(context *build.Context, saved []SavedPackage) {
+	for _, entry := range saved {
+		pkgInfo, err := context.ImportDir(entry.Dir, build.AllowBinary)
+		if err != nil {
+			continue
+		}
+		pkgInfo.ImportPath = entry.ImportPath
+		if !(&CachedPackage{ModTimes: entry.ModTimes}).IsUpToDate(pkgInfo) {
+			continue
+		}
+		cached, err := ParseCachedPackage(pkgInfo)
+		if err != nil {
+			continue
+		}
+		cached.LastUsed = entry.LastUsed
+		this.mutex.Lock()
+		if this.packages[cached.Dir] == nil {
+			this.packages[cached.Dir] = cached
+			this.EvictLocked()
+		}
+		this.mutex.Unlock()
+	}
+}
+
+// Removes least recently used packages above the limit
+func (this *PackageCache) EvictLocked() {
+	for len(this.packages) > this.MaxPackages {
+		var oldest *CachedPackage
+		for _, cached := range this.packages {
+			if oldest == nil || cached.LastUsed.Before(oldest.LastUsed) {
+				oldest = cached
+			}
+		}
+		delete(this.packages, oldest.Dir)
+	}
+}
+
+func ParseCachedPackage(pkgInfo *build.Package) (*CachedPackage, error) {
+	ret := &CachedPackage{
+		Dir:        pkgInfo.Dir,
+		ImportPath: pkgInfo.ImportPath,
+		Fset:       token.NewFileSet(),
+		Files:      make(map[string]*ast.File),
+		ModTimes:   make(map[string]time.Time),
+		LastUsed:   time.Now(),
+	}
+	for _, fileName := range pkgInfo.GoFiles {
+		filePath := filepath.Join(pkgInfo.Dir, fileName)
+		if info, err := os.Stat(filePath); err == nil {
+			ret.ModTimes[filePath] = info.ModTime()
+		}
+		fast, err := parser.ParseFile(ret.Fset, filePath, nil, parser.ParseComments)
+		if fast == nil {
+			return nil, err
+		}
+		if fast.Doc != nil && len(ret.Doc) == 0 {
+			ret.Doc = fast.Doc.Text()
+		}
+		if ast.FileExports(fast) {
+			ret.Files[filePath] = fast
+		}
+	}
+	pkgAst, err := ast.NewPackage(ret.Fset, ret.Files, nil, nil)
+	if pkgAst == nil {
+		return nil, err
+	}
+	ret.Scope = pkgAst.Scope
+	return ret, nil
+}
+
+// Checks that package has the same set of files and none of them changed
+func (this *CachedPackage) IsUpToDate(pkgInfo *build.Package) bool {
+	if len(pkgInfo.GoFiles) != len(this.ModTimes) {
+		return false
+	}
+	for _, fileName := range pkgInfo.GoFiles {
+		filePath := filepath.Join(pkgInfo.Dir, fileName)
+		modTime, ok := this.ModTimes[filePath]
+		if !ok {
+			return false
+		}
+		info, err := os.Stat(filePath)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return false
+		}
+	}
+	return true
+}
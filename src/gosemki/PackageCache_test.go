@@ -0,0 +1,40 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Saved cache is parsed again only for packages which didn't change
+func TestPackageCacheRestore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lib.go")
+	if err := os.WriteFile(path, []byte("package lib\n\nfunc Answer() int { return 42 }\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	context := build.Default
+	cache := NewPackageCache(0)
+	if _, err := cache.Import(&context, ".", dir); err != nil {
+		t.Fatal(err)
+	}
+	saved := cache.Save()
+
+	restored := NewPackageCache(0)
+	restored.Restore(&context, saved)
+	if restored.Len() != 1 {
+		t.Fatalf("expected unchanged package restored, got %d packages", restored.Len())
+	}
+
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	outdated := NewPackageCache(0)
+	outdated.Restore(&context, saved)
+	if outdated.Len() != 0 {
+		t.Errorf("expected changed package skipped, got %d packages", outdated.Len())
+	}
+}
@@ -9,10 +9,13 @@ import (
 	"go/parser"
 	"go/scanner"
 	"go/token"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -70,12 +73,32 @@ const (
 )
 
 type PackageIndexer struct {
-	fset        *token.FileSet
-	files       map[string]*ast.File
-	packageName string
-	result      *IndexerResult
-	lastIdent   *ast.Ident
-	context     build.Context
+	fset         *token.FileSet
+	files        map[string]*ast.File
+	packageName  string
+	result       *IndexerResult
+	lastIdent    *ast.Ident
+	context      build.Context
+	cache        *PackageCache
+	imported     map[string]*CachedPackage
+	importErrors map[string]error
+	srcDir       string
+	options      IndexerOptions
+	// syntax errors of files sent by editor
+	syntaxErrors scanner.ErrorList
+	// optional listener of results available after each phase
+	onPhase    func(phase string, path string, chunk *IndexerResult)
+	phaseStart IndexerResult
+	// converters of positions by file path, created on demand
+	encoders map[string]*PositionEncoder
+	// content of files sent by editor
+	contents map[string][]byte
+	// scope of indexed package, set by ResolvePackage
+	packageScope *ast.Scope
+	// root of registered workspace, empty for implicit one
+	workspaceRoot string
+	// names of methods and keys of composite literals, collected on demand
+	memberParents map[*ast.Ident]ast.Node
 }
 
 func NewPackageIndexer(result *IndexerResult) *PackageIndexer {
@@ -86,46 +109,15 @@ func NewPackageIndexer(result *IndexerResult) *PackageIndexer {
 
 func (this *PackageIndexer) ImportPackageScope(path string) (scope *ast.Scope) {
 	scope = ast.NewScope(nil)
-	// Infering source dir for local imports
-	// Not ideal solution since daemon workdir can vary
-	srcDir := "."
-	if build.IsLocalImport(path) {
-		srcDir, _ = os.Getwd()
-	}
-
-	// Try to locate package source code and parse it
-	pkgInfo, err := build.Import(path, srcDir, build.AllowBinary)
+	// Local imports are resolved relative to the indexed file
+	pkg, err := this.cache.Import(&this.context, path, this.srcDir)
 	if err != nil {
-		log.Printf("Calling build.Import() for package '%s' failed: %v", path, err)
+		log.Printf("Importing package '%s' failed: %v", path, err)
+		this.importErrors[path] = err
 		return
 	}
-
-	nodeMap := make(map[string]*ast.File)
-	for _, fileName := range pkgInfo.GoFiles {
-		filePath := filepath.Join(pkgInfo.SrcRoot, path, fileName)
-		fast, err := parser.ParseFile(this.fset, filePath, nil, parser.ParseComments)
-		if fast != nil {
-			if ast.FileExports(fast) {
-				nodeMap[filePath] = fast
-			}
-		} else {
-			log.Printf("Calling parser.ParseFile() for package '%s' failed: %v", path, err)
-			return
-		}
-	}
-	pkgAst, err := ast.NewPackage(this.fset, nodeMap, nil, nil)
-	if pkgAst != nil {
-		scope = pkgAst.Scope
-		exportedNames := make([]string, 0, 1024)
-		for key, _ := range scope.Objects {
-			exportedNames = append(exportedNames, key)
-		}
-		log.Printf("Parsed OK package '%s' ast: [%v]", path, exportedNames)
-	} else {
-		log.Printf("Calling ast.NewPackage() for package '%s' failed: %v", path, err)
-		return
-	}
-	return
+	this.imported[path] = pkg
+	return pkg.Scope
 }
 
 func (this *PackageIndexer) NewPackage(path string) *ast.Object {
@@ -153,23 +145,190 @@ func (this *PackageIndexer) Import(imports map[string]*ast.Object, path string)
 }
 
 func (this *PackageIndexer) Reindex(filePath string, file []byte) {
+	overlays := map[string][]byte{filePath: file}
+	results := map[string]*IndexerResult{filePath: this.result}
+	this.ReindexFiles(overlays, results)
+}
+
+// Indexes several files of the same package in single pass. Files content
+// comes from editor and can differ from disk, other package files are read
+// from disk. Each file gets own result.
+func (this *PackageIndexer) ReindexFiles(overlays map[string][]byte, results map[string]*IndexerResult) {
+	paths := this.ParseOverlays(overlays)
+	if viewport := this.options.Viewport; viewport != nil {
+		if encoder := this.GetEncoder(paths[0]); encoder != nil {
+			// viewport is sent in the same units as positions of result
+			this.options.Viewport = &GoViewport{
+				FromOffset: encoder.Decode(viewport.FromOffset),
+				ToOffset:   encoder.Decode(viewport.ToOffset),
+			}
+		}
+	}
+	withErrors := this.options.HasSection(SectionErrors)
+	withRanges := this.options.HasSection(SectionRanges)
+	if withErrors {
+		for _, path := range paths {
+			this.BeginPhase(results[path])
+			this.ParseErrorsInFile(this.syntaxErrors, path, true)
+			this.EndPhase(PhaseParse, path)
+		}
+	}
+	withOutline := this.options.HasSection(SectionOutline)
+	if withRanges || withErrors || withOutline {
+		// outline needs methods declared in other files
+		this.ParseSiblings(paths[0], overlays)
+	}
+	// syntax-only structure is available before imports resolution
+	if this.options.HasSection(SectionFolds) || withOutline {
+		for _, path := range paths {
+			this.BeginPhase(results[path])
+			if this.options.HasSection(SectionFolds) {
+				ast.Inspect(this.files[path], this.InspectStructure)
+			}
+			if withOutline {
+				this.result.Outline = append(this.result.Outline, this.BuildOutline(path)...)
+			}
+			this.EndPhase(PhaseStructure, path)
+		}
+	}
+	if !withRanges && !withErrors {
+		// imports are needed only for resolution
+		return
+	}
+
+	this.InjectBuiltinPackage()
+
+	pkgAst, errors := ast.NewPackage(this.fset, this.files, this.Import, nil)
+	if withRanges {
+		for _, path := range paths {
+			this.BeginPhase(results[path])
+			ast.Inspect(pkgAst.Files[path], this.InspectNode)
+			this.EndPhase(PhaseRanges, path)
+		}
+	}
+	if withErrors {
+		errorList, _ := errors.(scanner.ErrorList)
+		if this.options.StrictErrors {
+			errorList = append(errorList, this.CollectImportErrors(paths)...)
+			errorList.Sort()
+		}
+		for _, path := range paths {
+			this.BeginPhase(results[path])
+			this.ParseErrorsInFile(errorList, path, false)
+			this.EndPhase(PhaseSemantic, path)
+		}
+	}
+}
+
+// Resets state left by previous run and parses files sent by editor,
+// returns their paths in sorted order
+func (this *PackageIndexer) ParseOverlays(overlays map[string][]byte) []string {
+	paths := make([]string, 0, len(overlays))
+	for path := range overlays {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
 	this.packageName = ""
+	this.syntaxErrors = nil
 	this.fset = token.NewFileSet()
 	this.files = make(map[string]*ast.File)
+	this.imported = make(map[string]*CachedPackage)
+	this.importErrors = make(map[string]error)
+	this.srcDir = filepath.Dir(paths[0])
+	this.contents = make(map[string][]byte)
+	for path, content := range overlays {
+		this.contents[path] = content
+	}
+	this.encoders = make(map[string]*PositionEncoder)
+	this.memberParents = nil
+	if this.cache == nil {
+		this.cache = NewPackageCache(0)
+	}
 
-	this.Parse(filePath, file)
+	for _, path := range paths {
+		this.Parse(path, overlays[path])
+	}
+	return paths
+}
+
+// Parses package files which are not sent by editor
+func (this *PackageIndexer) ParseSiblings(filePath string, overlays map[string][]byte) {
 	for _, name := range this.FindAllPackageFiles(filePath) {
-		this.Parse(name, nil)
+		if _, isOverlay := overlays[name]; !isOverlay {
+			this.ParseSibling(name)
+		}
 	}
+}
+
+// Parses and resolves whole package without collecting results, used by
+// queries about single identifier
+func (this *PackageIndexer) ResolvePackage(overlays map[string][]byte) *ast.Package {
+	paths := this.ParseOverlays(overlays)
+	this.ParseSiblings(paths[0], overlays)
 	this.InjectBuiltinPackage()
+	pkgAst, _ := ast.NewPackage(this.fset, this.files, this.Import, nil)
+	this.packageScope = pkgAst.Scope
+	return pkgAst
+}
 
-	pkgAst, errors := ast.NewPackage(this.fset, this.files, this.Import, nil)
-	if errors != nil {
-		errorList, _ := errors.(scanner.ErrorList)
-		this.ParseErrorsInFile(errorList, filePath)
+// Resolver doesn't report imports which cannot be found, since
+// importer keeps them as empty packages to highlight package names
+func (this *PackageIndexer) CollectImportErrors(paths []string) (errorList scanner.ErrorList) {
+	for _, path := range paths {
+		for _, spec := range this.files[path].Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			// cgo pseudo-package is never found by go/build
+			if err := this.importErrors[importPath]; err != nil && importPath != "C" {
+				errorList.Add(this.fset.Position(spec.Path.Pos()), fmt.Sprintf("could not import %s (%v)", importPath, err))
+			}
+		}
 	}
-	fileAst := pkgAst.Files[filePath]
-	ast.Inspect(fileAst, this.InspectNode)
+	return
+}
+
+// Returns nil if positions are requested in bytes
+func (this *PackageIndexer) GetEncoder(path string) *PositionEncoder {
+	if len(this.options.Encoding) == 0 || this.options.Encoding == EncodingBytes {
+		return nil
+	}
+	encoder := this.encoders[path]
+	if encoder == nil {
+		if content, ok := this.contents[path]; ok {
+			encoder = NewPositionEncoder(content, this.options.Encoding)
+			this.encoders[path] = encoder
+		}
+	}
+	return encoder
+}
+
+// Starts collecting results of the next indexing phase for the file
+func (this *PackageIndexer) BeginPhase(result *IndexerResult) {
+	this.result = result
+	this.phaseStart = IndexerResult{
+		Ranges:  result.Ranges,
+		Errors:  result.Errors,
+		Folds:   result.Folds,
+		Outline: result.Outline,
+	}
+}
+
+// Converts positions of elements added since BeginPhase() into requested
+// encoding and reports them to the phase listener
+func (this *PackageIndexer) EndPhase(phase string, path string) {
+	chunk := &IndexerResult{
+		Ranges:  this.result.Ranges[len(this.phaseStart.Ranges):],
+		Errors:  this.result.Errors[len(this.phaseStart.Errors):],
+		Folds:   this.result.Folds[len(this.phaseStart.Folds):],
+		Outline: this.result.Outline[len(this.phaseStart.Outline):],
+	}
+	if encoder := this.GetEncoder(path); encoder != nil {
+		encoder.EncodeResult(chunk, this.GetEncoder)
+	}
+	if this.onPhase == nil {
+		return
+	}
+	this.onPhase(phase, path, chunk)
 }
 
 func (this *PackageIndexer) AddIdentRange(ident *ast.Ident) {
@@ -177,39 +336,58 @@ func (this *PackageIndexer) AddIdentRange(ident *ast.Ident) {
 		return
 	}
 
-	pos := this.fset.Position(ident.NamePos)
-	goRange := GoRange{
-		GoPos: GoPos{
-			Line:   pos.Line,
-			Column: pos.Column,
-			Offset: pos.Offset,
-		},
-		Length: len(ident.Name),
-		Kind:   inferIdentKind(ident),
-	}
-	this.result.AddRange(goRange)
+	this.result.AddRange(this.MakeRange(ident, inferIdentKind(ident)))
 }
 
 func (this *PackageIndexer) AddFuncCallRange(expr *ast.CallExpr) {
-	pos := this.fset.Position(expr.Fun.Pos())
-	end := this.fset.Position(expr.Fun.End())
-	length := pos.Column - end.Column
-	if end.Line != pos.Line {
-		length = end.Column
-	}
-	goRange := GoRange{
-		GoPos: GoPos{
-			Line:   pos.Line,
-			Column: pos.Column,
-			Offset: pos.Offset,
+	this.result.AddRange(this.MakeRange(expr.Fun, GoKindFunc))
+}
+
+// Range spanning the node, length is byte distance from start to end
+func (this *PackageIndexer) MakeRange(node ast.Node, kind GoKind) GoRange {
+	pos := this.MakeGoPos(node.Pos())
+	end := this.MakeGoPos(node.End())
+	return GoRange{
+		GoPos:  pos,
+		End:    end,
+		Length: end.Offset - pos.Offset,
+		Kind:   kind,
+	}
+}
+
+func (this *PackageIndexer) MakeGoPos(pos token.Pos) GoPos {
+	return MakeGoPosInFileSet(this.fset, pos)
+}
+
+// Same as MakeGoPos for positions of imported packages, which have own FileSet
+func MakeGoPosInFileSet(fset *token.FileSet, pos token.Pos) GoPos {
+	position := fset.Position(pos)
+	return GoPos{
+		Line:   position.Line,
+		Column: position.Column,
+		Offset: position.Offset,
+	}
+}
+
+func (this *PackageIndexer) MakeOutline(name *ast.Ident, decl ast.Node, kind GoKind) GoOutline {
+	return GoOutline{
+		GoPos: this.MakeGoPos(name.Pos()),
+		End:   this.MakeGoPos(name.End()),
+		Decl: GoSpan{
+			Start: this.MakeGoPos(decl.Pos()),
+			End:   this.MakeGoPos(decl.End()),
 		},
-		Length: length,
-		Kind:   GoKindFunc,
+		Name: name.Name,
+		Kind: kind,
 	}
-	this.result.AddRange(goRange)
 }
 
 func (this *PackageIndexer) InspectNode(node ast.Node) bool {
+	if node != nil && this.options.Viewport != nil {
+		if !this.options.Viewport.Intersects(this.NodePos(node).Offset, this.NodeEnd(node).Offset) {
+			return false
+		}
+	}
 	switch x := node.(type) {
 	case *ast.Ident:
 		this.AddIdentRange(x)
@@ -228,36 +406,19 @@ func (this *PackageIndexer) InspectNode(node ast.Node) bool {
 		return false
 	case *ast.Comment:
 		return false
+	}
+	return true
+}
+
+// Collects folds, needs only syntax tree
+func (this *PackageIndexer) InspectStructure(node ast.Node) bool {
+	switch x := node.(type) {
 	case *ast.FuncDecl:
 		goScope := GoFoldScope{
 			LineFrom: this.NodePos(x).Line,
 			LineTo:   this.NodeEnd(x).Line,
 		}
 		this.result.AddFoldScope(goScope)
-		pos := this.fset.Position(x.Name.Pos())
-		goOutline := GoOutline{
-			GoPos: GoPos{
-				Line:   pos.Line,
-				Column: pos.Column,
-				Offset: pos.Offset,
-			},
-			Name: x.Name.Name,
-			Kind: GoKindFunc,
-		}
-		this.result.AddOutline(goOutline)
-		return true
-	case *ast.TypeSpec:
-		pos := this.fset.Position(x.Name.Pos())
-		goOutline := GoOutline{
-			GoPos: GoPos{
-				Line:   pos.Line,
-				Column: pos.Column,
-				Offset: pos.Offset,
-			},
-			Name: x.Name.Name,
-			Kind: GoKindType,
-		}
-		this.result.AddOutline(goOutline)
 		return true
 	}
 	return true
@@ -275,27 +436,78 @@ func (this *PackageIndexer) NodeEnd(node ast.Node) token.Position {
 func (this *PackageIndexer) InjectBuiltinPackage() {
 	var hackContent bytes.Buffer
 	hackContent.WriteString("package ")
-	hackContent.WriteString(this.packageName)
+	if len(this.packageName) != 0 {
+		hackContent.WriteString(this.packageName)
+	} else {
+		// file without package clause still sees predeclared names
+		hackContent.WriteString("_")
+	}
 	hackContent.WriteString(";\n")
 	hackContent.WriteString(BUILTIN_PKG_CONTENT)
 	this.Parse("", hackContent.Bytes())
 }
 
 // Translates *scanner.ErrorList into []GoError
-func (this *PackageIndexer) ParseErrorsInFile(errors scanner.ErrorList, filePath string) {
+func (this *PackageIndexer) ParseErrorsInFile(errors scanner.ErrorList, filePath string, syntax bool) {
 	for _, scanError := range errors {
 		if scanError.Pos.Filename == filePath {
 			var goerr GoError
 			goerr.Line = scanError.Pos.Line
 			goerr.Column = scanError.Pos.Column
-			goerr.Length = len(scanError.Pos.String())
 			goerr.Offset = scanError.Pos.Offset
+			goerr.Length = this.TokenLengthAt(filePath, scanError.Pos.Offset)
+			goerr.End = goerr.GoPos
+			if tokenFile := this.GetTokenFile(filePath); tokenFile != nil && goerr.Length > 0 {
+				goerr.End = this.MakeGoPos(tokenFile.Pos(goerr.Offset + goerr.Length))
+			}
 			goerr.Message = scanError.Msg
+			// parser also reports redeclarations inside the file
+			goerr.Code = ClassifySemanticError(scanError.Msg)
+			if syntax && goerr.Code == ErrorCodeSemantic {
+				goerr.Code = ErrorCodeSyntax
+			}
 			this.result.AddError(goerr)
 		}
 	}
 }
 
+// Returns nil if file is not parsed. File without package clause has no
+// valid positions, so its token file is found by name.
+func (this *PackageIndexer) GetTokenFile(filePath string) (found *token.File) {
+	this.fset.Iterate(func(tokenFile *token.File) bool {
+		if tokenFile.Name() == filePath {
+			found = tokenFile
+		}
+		return found == nil
+	})
+	return
+}
+
+// Length of source token starting at byte offset, errors are reported
+// at token start
+func (this *PackageIndexer) TokenLengthAt(filePath string, offset int) int {
+	content := this.contents[filePath]
+	if offset < 0 || offset >= len(content) {
+		return 0
+	}
+	src := content[offset:]
+	fset := token.NewFileSet()
+	var tokenScanner scanner.Scanner
+	tokenScanner.Init(fset.AddFile("", -1, len(src)), src, nil, scanner.ScanComments)
+	pos, tok, lit := tokenScanner.Scan()
+	if pos != token.Pos(1) || tok == token.EOF {
+		// error points to whitespace or unknown character
+		return 0
+	}
+	if tok == token.SEMICOLON && lit == "\n" {
+		return 0
+	}
+	if len(lit) != 0 {
+		return len(lit)
+	}
+	return len(tok.String())
+}
+
 // Finds other files from the same packge as parsed file
 func (this *PackageIndexer) FindAllPackageFiles(filePath string) []string {
 	dir := path.Dir(filePath)
@@ -310,20 +522,57 @@ func (this *PackageIndexer) FindAllPackageFiles(filePath string) []string {
 	}
 	var result []string
 	for _, name := range names {
-		if strings.HasSuffix(name, ".go") {
-			result = append(result, path.Join(dir, name))
+		if !strings.HasSuffix(name, ".go") {
+			continue
 		}
+		// skip files excluded by build constraints of the workspace
+		if match, err := this.context.MatchFile(dir, name); err == nil && !match {
+			continue
+		}
+		result = append(result, path.Join(dir, name))
 	}
 	return result
 }
 
 func (this *PackageIndexer) Parse(filePath string, src interface{}) {
-	fast, err := parser.ParseFile(this.fset, filePath, src, parser.ParseComments)
+	mode := parser.ParseComments
+	if this.options.StrictErrors {
+		mode |= parser.DeclarationErrors
+	}
+	fast, err := parser.ParseFile(this.fset, filePath, src, mode)
 	if fast == nil {
 		panic(errors.New(fmt.Sprintf("Failed to index file, error: '%v'", err)))
 	}
 	this.files[filePath] = fast
+	if errorList, ok := err.(scanner.ErrorList); ok {
+		this.syntaxErrors = append(this.syntaxErrors, errorList...)
+	}
 	if len(this.packageName) == 0 {
 		this.packageName = fast.Name.Name
 	}
 }
+
+// Parses other file from the same dir, ignores files from another package
+// (like external tests) since they cannot be resolved together
+func (this *PackageIndexer) ParseSibling(filePath string) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return
+	}
+	fast, _ := parser.ParseFile(this.fset, filePath, content, parser.ParseComments)
+	if fast == nil || fast.Name.Name != this.packageName {
+		return
+	}
+	this.files[filePath] = fast
+	// outline can refer to methods declared in siblings
+	this.contents[filePath] = content
+}
+
+// Reads only package clause, returns empty string if file is malformed
+func ParsePackageName(filePath string, src []byte) string {
+	fast, _ := parser.ParseFile(token.NewFileSet(), filePath, src, parser.PackageClauseOnly)
+	if fast == nil || fast.Name == nil {
+		return ""
+	}
+	return fast.Name.Name
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func IndexTestFile(t *testing.T, content string) IndexerResult {
+	var result IndexerResult
+	indexer := NewPackageIndexer(&result)
+	indexer.context = build.Default
+	indexer.Reindex(filepath.Join(t.TempDir(), "new.go"), []byte(content))
+	return result
+}
+
+// New file being typed and typo in package clause must give syntax error,
+// not panic of the indexer
+func TestReindexWithoutPackageClause(t *testing.T) {
+	for _, content := range []string{"", "pakage typo\n\nfunc f() {}\n", "func f() {}\n"} {
+		result := IndexTestFile(t, content)
+		if len(result.Errors) == 0 {
+			t.Errorf("%q: expected syntax error", content)
+			continue
+		}
+		if goError := result.Errors[0]; !strings.Contains(goError.Message, "expected 'package'") || goError.Code != ErrorCodeSyntax {
+			t.Errorf("%q: unexpected error %+v", content, goError)
+		}
+	}
+}
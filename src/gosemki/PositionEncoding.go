@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"unicode/utf8"
+)
+
+// Units of GoPos.Column, GoPos.Offset and lengths in IndexerResult
+const (
+	EncodingBytes = "bytes"  // UTF-8 bytes, default
+	EncodingUtf16 = "utf-16" // UTF-16 code units, used by LSP, Qt and JavaScript
+	EncodingRunes = "runes"  // Unicode code points
+)
+
+func CheckPositionEncoding(encoding string) error {
+	switch encoding {
+	case "", EncodingBytes, EncodingUtf16, EncodingRunes:
+		return nil
+	}
+	return errors.New("unknown position encoding '" + encoding + "', expected bytes, utf-16 or runes")
+}
+
+// Converts byte-based positions of single file into other units
+type PositionEncoder struct {
+	content    []byte
+	encoding   string
+	lineStarts []int
+	// units before start of each line
+	lineUnits []int
+}
+
+func NewPositionEncoder(content []byte, encoding string) *PositionEncoder {
+	ret := &PositionEncoder{content: content, encoding: encoding, lineStarts: []int{0}, lineUnits: []int{0}}
+	for i, c := range content {
+		if c == '\n' {
+			units := ret.lineUnits[len(ret.lineUnits)-1] + ret.CountUnits(ret.lineStarts[len(ret.lineStarts)-1], i+1)
+			ret.lineStarts = append(ret.lineStarts, i+1)
+			ret.lineUnits = append(ret.lineUnits, units)
+		}
+	}
+	return ret
+}
+
+// Number of encoding units in content[from:to]
+func (this *PositionEncoder) CountUnits(from, to int) int {
+	if from < 0 {
+		from = 0
+	}
+	if to > len(this.content) {
+		to = len(this.content)
+	}
+	if from >= to {
+		return 0
+	}
+	if this.encoding != EncodingUtf16 && this.encoding != EncodingRunes {
+		return to - from
+	}
+	units := 0
+	for offset := from; offset < to; {
+		r, size := utf8.DecodeRune(this.content[offset:])
+		units += this.RuneUnits(r, size)
+		offset += size
+	}
+	return units
+}
+
+// Number of encoding units taken by rune encoded in size bytes
+func (this *PositionEncoder) RuneUnits(r rune, size int) int {
+	switch this.encoding {
+	case EncodingUtf16:
+		if r >= 0x10000 {
+			return 2
+		}
+		return 1
+	case EncodingRunes:
+		return 1
+	}
+	return size
+}
+
+// Converts units counted from start of 0-based line into byte offset,
+// positions past line end are clamped to it
+func (this *PositionEncoder) DecodeInLine(line int, units int) int {
+	if line < 0 {
+		return 0
+	}
+	if line >= len(this.lineStarts) {
+		return len(this.content)
+	}
+	offset := this.lineStarts[line]
+	for count := 0; count < units && offset < len(this.content) && this.content[offset] != '\n'; {
+		r, size := utf8.DecodeRune(this.content[offset:])
+		count += this.RuneUnits(r, size)
+		offset += size
+	}
+	return offset
+}
+
+// Converts offset from file start measured in units into byte offset
+func (this *PositionEncoder) Decode(units int) int {
+	if units <= 0 {
+		return 0
+	}
+	line := sort.Search(len(this.lineUnits), func(i int) bool {
+		return this.lineUnits[i] > units
+	}) - 1
+	return this.DecodeInLine(line, units-this.lineUnits[line])
+}
+
+// Converts position and length of element starting at this position
+func (this *PositionEncoder) Encode(pos *GoPos, length *int) {
+	line := pos.Line - 1
+	if line < 0 || line >= len(this.lineStarts) {
+		return
+	}
+	offset := pos.Offset
+	start := this.lineStarts[line]
+	if length != nil {
+		*length = this.CountUnits(offset, offset+*length)
+	}
+	pos.Column = this.CountUnits(start, offset) + 1
+	pos.Offset = this.lineUnits[line] + pos.Column - 1
+}
+
+// Converts all positions of result elements, outline items declared in
+// other files are converted by encoders of these files
+func (this *PositionEncoder) EncodeResult(result *IndexerResult, otherFile func(path string) *PositionEncoder) {
+	for i := range result.Ranges {
+		this.Encode(&result.Ranges[i].GoPos, &result.Ranges[i].Length)
+		this.Encode(&result.Ranges[i].End, nil)
+	}
+	this.EncodeOutline(result.Outline, otherFile)
+	for i := range result.Errors {
+		this.Encode(&result.Errors[i].GoPos, &result.Errors[i].Length)
+		this.Encode(&result.Errors[i].End, nil)
+	}
+}
+
+func (this *PositionEncoder) EncodeOutline(outline []GoOutline, otherFile func(path string) *PositionEncoder) {
+	for i := range outline {
+		item := &outline[i]
+		encoder := this
+		if len(item.Path) != 0 {
+			if encoder = otherFile(item.Path); encoder == nil {
+				continue
+			}
+		}
+		encoder.Encode(&item.GoPos, nil)
+		encoder.Encode(&item.End, nil)
+		encoder.Encode(&item.Decl.Start, nil)
+		encoder.Encode(&item.Decl.End, nil)
+		this.EncodeOutline(item.Children, otherFile)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Finds uses of identifier at byte offset of the file sent by editor.
+// Package of the file is searched first, then exported package members
+// are searched in packages of registered workspace which import declaring
+// package. Declaring identifier is included only if withDecl is set.
+func (this *PackageIndexer) FindReferences(filePath string, content []byte, offset int, withDecl bool) (GoDefinition, []GoLocation, error) {
+	definition, err := this.FindDefinition(filePath, content, offset)
+	if err != nil {
+		return definition, nil, err
+	}
+	importPath := ""
+	if pkgInfo, err := this.context.ImportDir(filepath.Dir(definition.Path), build.FindOnly); err == nil && pkgInfo.ImportPath != "." {
+		importPath = pkgInfo.ImportPath
+	}
+	references := this.CollectReferences(&definition, importPath)
+	if len(this.workspaceRoot) != 0 && len(importPath) != 0 && this.IsPackageMember(&definition) {
+		references = append(references, this.FindWorkspaceReferences(&definition, importPath)...)
+	}
+	if withDecl {
+		references = append(references, definition.GoLocation)
+	}
+	sort.Slice(references, func(i, j int) bool {
+		if references[i].Path != references[j].Path {
+			return references[i].Path < references[j].Path
+		}
+		return references[i].Offset < references[j].Offset
+	})
+	return definition, references, nil
+}
+
+// Only exported members of package scope can be used by other packages
+func (this *PackageIndexer) IsPackageMember(definition *GoDefinition) bool {
+	if !ast.IsExported(definition.Name) {
+		return false
+	}
+	if _, isIndexed := this.files[definition.Path]; !isIndexed {
+		// declared in imported package, which has only package scope
+		return true
+	}
+	obj := this.packageScope.Lookup(definition.Name)
+	if obj == nil {
+		return false
+	}
+	found, err := MakeDefinition(obj, this.fset)
+	return err == nil && found.Path == definition.Path && found.Offset == definition.Offset
+}
+
+// Searches packages of workspace which import declaring package, the
+// package itself if it's inside of workspace, and external tests
+func (this *PackageIndexer) FindWorkspaceReferences(definition *GoDefinition, importPath string) (references []GoLocation) {
+	declDir := filepath.Dir(definition.Path)
+	WalkPackageDirs(this.workspaceRoot, func(dir string) {
+		pkgInfo, err := this.context.ImportDir(dir, 0)
+		if err != nil {
+			return
+		}
+		// any file resolves the whole package with its tests
+		firstFile := ""
+		if len(pkgInfo.GoFiles) != 0 {
+			firstFile = pkgInfo.GoFiles[0]
+		} else if len(pkgInfo.TestGoFiles) != 0 {
+			firstFile = pkgInfo.TestGoFiles[0]
+		}
+		isImporter := dir == declDir || ContainsString(pkgInfo.Imports, importPath) || ContainsString(pkgInfo.TestImports, importPath)
+		// package of indexed file is already searched
+		if dir != this.srcDir && isImporter && len(firstFile) != 0 {
+			references = append(references, this.FindPackageReferences(filepath.Join(dir, firstFile), definition, importPath)...)
+		}
+		if ContainsString(pkgInfo.XTestImports, importPath) {
+			references = append(references, this.FindPackageReferences(filepath.Join(dir, pkgInfo.XTestGoFiles[0]), definition, importPath)...)
+		}
+	})
+	return
+}
+
+// Resolves package of file read from disk and searches it
+func (this *PackageIndexer) FindPackageReferences(filePath string, definition *GoDefinition, importPath string) []GoLocation {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil
+	}
+	indexer := new(PackageIndexer)
+	indexer.context = this.context
+	indexer.cache = this.cache
+	indexer.ResolvePackage(map[string][]byte{filePath: content})
+	return indexer.CollectReferences(definition, importPath)
+}
+
+// Finds identifiers of resolved package which refer to the definition,
+// except declaring identifier itself
+func (this *PackageIndexer) CollectReferences(definition *GoDefinition, importPath string) (references []GoLocation) {
+	for path, file := range this.files {
+		if len(path) == 0 {
+			// builtin declarations
+			continue
+		}
+		VisitIdents(file, func(ident *ast.Ident, selector *ast.SelectorExpr) {
+			if ident.Name != definition.Name || !this.IsReferenceTo(ident, selector, definition, importPath) {
+				return
+			}
+			location := MakeLocation(this.fset, ident)
+			if location.Path != definition.Path || location.Offset != definition.Offset {
+				references = append(references, location)
+			}
+		})
+	}
+	return
+}
+
+// Members of imported package are compared by name, since editor can
+// have unsaved changes of declaring file and positions differ. Fields and
+// methods can repeat names, they are compared by position.
+func (this *PackageIndexer) IsReferenceTo(ident *ast.Ident, selector *ast.SelectorExpr, definition *GoDefinition, importPath string) bool {
+	obj, fset := this.ResolveIdent(ident, selector)
+	if obj == nil {
+		return false
+	}
+	if pkg := this.GetImportedByFileSet(fset); pkg != nil && len(importPath) != 0 && pkg.Scope.Lookup(obj.Name) == obj {
+		return pkg.ImportPath == importPath
+	}
+	found, err := MakeDefinition(obj, fset)
+	return err == nil && found.Path == definition.Path && found.Offset == definition.Offset
+}
+
+// Calls visit for each identifier of the file, selector is set for
+// identifiers selected from other expression
+func VisitIdents(file *ast.File, visit func(ident *ast.Ident, selector *ast.SelectorExpr)) {
+	var inspect func(node ast.Node) bool
+	inspect = func(node ast.Node) bool {
+		switch x := node.(type) {
+		case *ast.SelectorExpr:
+			ast.Inspect(x.X, inspect)
+			visit(x.Sel, x)
+			return false
+		case *ast.Ident:
+			visit(x, nil)
+		}
+		return true
+	}
+	ast.Inspect(file, inspect)
+}
+
+func ContainsString(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *Client) ExecReferences() int {
+	flagSet := flag.NewFlagSet("references", flag.ExitOnError)
+	offset := flagSet.Int("offset", -1, "byte offset of identifier in file")
+	withDecl := flagSet.Bool("decl", false, "include declaration")
+	asJson := flagSet.Bool("json", false, "print references as JSON")
+	this.CommandArgs = ParseCommandFlags(flagSet, this.CommandArgs)
+	if *offset < 0 {
+		panic(errors.New("missed -offset=<offset> option"))
+	}
+	content, path := this.ReadEditorFile()
+	context := PackGoBuildContext(&build.Default)
+	reply := ClientFindReferences(this.RpcClient, content, path, context, *offset, *withDecl)
+	if !reply.Found {
+		fmt.Fprintf(os.Stderr, "%s\n", reply.Reason)
+		return 1
+	}
+	if *asJson {
+		jsonBytes, err := json.Marshal(reply.References)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", string(jsonBytes))
+		return 0
+	}
+	// grep-like output with line text, understood by vim quickfix
+	contents := map[string][]byte{path: content}
+	for _, location := range reply.References {
+		if _, ok := contents[location.Path]; !ok {
+			contents[location.Path], _ = ioutil.ReadFile(location.Path)
+		}
+		fmt.Printf("%s:%d:%d: %s\n", GetDisplayPath(location.Path), location.Line, location.Column, GetLineText(contents[location.Path], location.GoPos))
+	}
+	return 0
+}
+
+// Text of line containing the position without surrounding spaces
+func GetLineText(content []byte, pos GoPos) string {
+	lineStart := pos.Offset - pos.Column + 1
+	if lineStart < 0 || pos.Offset > len(content) {
+		return ""
+	}
+	line := content[lineStart:]
+	if end := strings.IndexByte(string(line), '\n'); end >= 0 {
+		line = line[:end]
+	}
+	return strings.TrimSpace(string(line))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	SARIF_VERSION = "2.1.0"
+	SARIF_SCHEMA  = "https://json.schemastore.org/sarif-2.1.0.json"
+	// base of relative artifact paths
+	SARIF_SRCROOT = "%SRCROOT%"
+)
+
+// Subset of Static Analysis Results Interchange Format 2.1.0
+type SarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []SarifRun `json:"runs"`
+}
+
+type SarifRun struct {
+	Tool               SarifTool                   `json:"tool"`
+	OriginalUriBaseIds map[string]SarifArtifactUri `json:"originalUriBaseIds,omitempty"`
+	ColumnKind         string                      `json:"columnKind"`
+	Results            []SarifResult               `json:"results"`
+}
+
+type SarifTool struct {
+	Driver SarifDriver `json:"driver"`
+}
+
+type SarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationUri string      `json:"informationUri"`
+	Rules          []SarifRule `json:"rules"`
+}
+
+type SarifRule struct {
+	Id                   string             `json:"id"`
+	ShortDescription     SarifMessage       `json:"shortDescription"`
+	DefaultConfiguration SarifConfiguration `json:"defaultConfiguration"`
+}
+
+type SarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type SarifMessage struct {
+	Text string `json:"text"`
+}
+
+type SarifResult struct {
+	RuleId    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   SarifMessage    `json:"message"`
+	Locations []SarifLocation `json:"locations"`
+}
+
+type SarifLocation struct {
+	PhysicalLocation SarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type SarifPhysicalLocation struct {
+	ArtifactLocation SarifArtifactUri `json:"artifactLocation"`
+	Region           SarifRegion      `json:"region"`
+}
+
+type SarifArtifactUri struct {
+	Uri       string `json:"uri"`
+	UriBaseId string `json:"uriBaseId,omitempty"`
+}
+
+type SarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+// Rules in order of SarifResult.RuleIndex
+var g_sarifRules = []SarifRule{
+	{Id: ErrorCodeSyntax, ShortDescription: SarifMessage{"Go syntax error"}},
+	{Id: ErrorCodeUnresolvedIdent, ShortDescription: SarifMessage{"Identifier is not declared"}},
+	{Id: ErrorCodeUnresolvedImport, ShortDescription: SarifMessage{"Imported package cannot be found"}},
+	{Id: ErrorCodeRedeclaration, ShortDescription: SarifMessage{"Name is declared twice in the same block"}},
+	{Id: ErrorCodePackageMismatch, ShortDescription: SarifMessage{"File belongs to other package"}},
+	{Id: ErrorCodeSemantic, ShortDescription: SarifMessage{"Other semantic error"}},
+}
+
+// Writes SARIF log with single run, columns must be in UTF-16 code units
+func FormatSarifErrors(writer io.Writer, report *ErrorReport) {
+	run := SarifRun{
+		Tool: SarifTool{Driver: SarifDriver{
+			Name:           "gosemki",
+			Version:        GOSEMKI_VERSION,
+			InformationUri: "https://github.com/sergey-shambir/gosemki",
+			Rules:          make([]SarifRule, len(g_sarifRules)),
+		}},
+		ColumnKind: "utf16CodeUnits",
+		Results:    []SarifResult{},
+	}
+	for i, rule := range g_sarifRules {
+		rule.DefaultConfiguration.Level = "error"
+		run.Tool.Driver.Rules[i] = rule
+	}
+	if cwd, err := os.Getwd(); err == nil {
+		run.OriginalUriBaseIds = map[string]SarifArtifactUri{
+			SARIF_SRCROOT: {Uri: LspPathToUri(cwd) + "/"},
+		}
+	}
+	for _, fileError := range report.Errors {
+		artifact := SarifArtifactUri{Uri: filepath.ToSlash(fileError.Path), UriBaseId: SARIF_SRCROOT}
+		if filepath.IsAbs(fileError.Path) {
+			artifact = SarifArtifactUri{Uri: LspPathToUri(fileError.Path)}
+		}
+		end := fileError.End
+		if end.Offset <= fileError.Offset {
+			end = fileError.GoPos
+		}
+		run.Results = append(run.Results, SarifResult{
+			RuleId:    fileError.Code,
+			RuleIndex: GetSarifRuleIndex(fileError.Code),
+			Level:     "error",
+			Message:   SarifMessage{fileError.Message},
+			Locations: []SarifLocation{{PhysicalLocation: SarifPhysicalLocation{
+				ArtifactLocation: artifact,
+				Region: SarifRegion{
+					StartLine:   fileError.Line,
+					StartColumn: fileError.Column,
+					EndLine:     end.Line,
+					EndColumn:   end.Column,
+				},
+			}}},
+		})
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(&SarifLog{Schema: SARIF_SCHEMA, Version: SARIF_VERSION, Runs: []SarifRun{run}})
+}
+
+func GetSarifRuleIndex(code string) int {
+	for i, rule := range g_sarifRules {
+		if rule.Id == code {
+			return i
+		}
+	}
+	return len(g_sarifRules) - 1
+}
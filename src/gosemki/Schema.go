@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// JSON Schema of IndexerResult output, printed by 'gosemki schema'
+const RESULT_JSON_SCHEMA = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/sergey-shambir/gosemki/result-v%VERSION%.json",
+  "title": "gosemki indexer result",
+  "type": "object",
+  "required": ["version", "ranges", "outline", "errors", "folds", "in_panic"],
+  "properties": {
+    "version": {"const": %VERSION%},
+    "ranges": {
+      "description": "Hints for identifiers highlighting",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["lin", "col", "off", "end", "len", "knd"],
+        "properties": {
+          "lin": {"$ref": "#/definitions/line"},
+          "col": {"$ref": "#/definitions/column"},
+          "off": {"$ref": "#/definitions/offset"},
+          "end": {"$ref": "#/definitions/position"},
+          "len": {"type": "integer", "minimum": 0},
+          "knd": {"$ref": "#/definitions/kind"}
+        }
+      }
+    },
+    "outline": {
+      "description": "Tree of document outline items",
+      "type": "array",
+      "items": {"$ref": "#/definitions/outlineItem"}
+    },
+    "errors": {
+      "description": "Syntax and semantic errors",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["lin", "col", "off", "end", "len", "msg", "code"],
+        "properties": {
+          "lin": {"$ref": "#/definitions/line"},
+          "col": {"$ref": "#/definitions/column"},
+          "off": {"$ref": "#/definitions/offset"},
+          "end": {"$ref": "#/definitions/position"},
+          "len": {"type": "integer", "minimum": 0},
+          "msg": {"type": "string"},
+          "code": {"enum": ["syntax-error", "unresolved-identifier", "unresolved-import", "redeclaration", "package-mismatch", "semantic-error"]}
+        }
+      }
+    },
+    "folds": {
+      "description": "Line ranges for code folding",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["from", "to"],
+        "properties": {
+          "from": {"$ref": "#/definitions/line"},
+          "to": {"$ref": "#/definitions/line"}
+        }
+      }
+    },
+    "in_panic": {
+      "description": "True if daemon panicked while indexing",
+      "type": "boolean"
+    }
+  },
+  "definitions": {
+    "line": {"type": "integer", "minimum": 1},
+    "column": {"type": "integer", "minimum": 1},
+    "offset": {"type": "integer", "minimum": 0},
+    "position": {
+      "description": "End of element, exclusive",
+      "type": "object",
+      "required": ["lin", "col", "off"],
+      "properties": {
+        "lin": {"$ref": "#/definitions/line"},
+        "col": {"$ref": "#/definitions/column"},
+        "off": {"$ref": "#/definitions/offset"}
+      }
+    },
+    "outlineItem": {
+      "type": "object",
+      "required": ["lin", "col", "off", "end", "decl", "str", "knd"],
+      "properties": {
+        "lin": {"$ref": "#/definitions/line"},
+        "col": {"$ref": "#/definitions/column"},
+        "off": {"$ref": "#/definitions/offset"},
+        "end": {"$ref": "#/definitions/position"},
+        "decl": {
+          "description": "Span of whole declaration",
+          "type": "object",
+          "required": ["start", "end"],
+          "properties": {
+            "start": {"$ref": "#/definitions/position"},
+            "end": {"$ref": "#/definitions/position"}
+          }
+        },
+        "str": {"type": "string"},
+        "knd": {"$ref": "#/definitions/kind"},
+        "detail": {"description": "Signature or type", "type": "string"},
+        "path": {"description": "File where item is declared if it is other package file", "type": "string"},
+        "children": {
+          "description": "Fields and methods of type, names of const or var group",
+          "type": "array",
+          "items": {"$ref": "#/definitions/outlineItem"}
+        }
+      }
+    },
+    "kind": {"enum": ["pkg", "con", "typ", "var", "fld", "fun", "lbl"]}
+  }
+}
+`
+
+func (this *Client) ExecSchema() int {
+	fmt.Print(strings.Replace(RESULT_JSON_SCHEMA, "%VERSION%", fmt.Sprint(RESULT_SCHEMA_VERSION), -1))
+	return 0
+}
@@ -3,88 +3,525 @@ package main
 import (
 	"errors"
 	"fmt"
+	"go/build"
 	"net"
+	"net/http"
 	"net/rpc"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"sync"
+	"syscall"
+	"time"
 )
 
 const (
 	CommandCloseDaemon = iota
 )
 
-type Server struct {
-	Socket   string
+const (
+	STALE_SOCKET_DIAL_TIMEOUT = time.Second
+)
+
+// Listening socket and protocol spoken on its connections
+type ServerListener struct {
+	Network  string
+	Address  string
+	JsonRpc  bool // JSON-RPC 2.0 instead of net/rpc gob encoding
+	Http     bool // HTTP/JSON endpoints served by HttpHandler
 	Listener net.Listener
-	CmdInput chan int
 }
 
-func (this *Server) Exec(socket string) int {
-	this.Socket = socket
-	if FileExists(this.Socket) {
-		fmt.Printf("unix socket: '%s' already exists\n", this.Socket)
+// Authenticated connection waiting to be served
+type ServerConn struct {
+	Conn     net.Conn
+	Listener *ServerListener
+}
+
+type Server struct {
+	Network         string
+	Address         string
+	JsonRpcNetwork  string
+	JsonRpcAddress  string
+	HttpNetwork     string
+	HttpAddress     string
+	TokenFile       string
+	Token           string
+	ShutdownTimeout time.Duration
+	Listeners       []*ServerListener
+	CmdInput        chan int
+	Workspaces      *WorkspaceRegistry
+	Streams         *StreamRegistry
+
+	mutex      sync.Mutex
+	closing    bool
+	conns      map[net.Conn]bool
+	requests   sync.WaitGroup
+	httpServer *http.Server
+}
+
+var ErrShuttingDown = errors.New("daemon is shutting down")
+
+func NewServer() *Server {
+	ret := new(Server)
+	ret.Workspaces = NewWorkspaceRegistry()
+	ret.Streams = NewStreamRegistry()
+	ret.conns = make(map[net.Conn]bool)
+	ret.CmdInput = make(chan int, 1)
+	return ret
+}
+
+func (this *Server) Exec(network, address string) int {
+	this.Network = network
+	this.Address = address
+	defer this.CloseListeners()
+	if _, err := this.Listen(network, address); err != nil {
+		fmt.Printf("%s\n", err.Error())
 		return 1
 	}
-	var err error
-	this.Listener, err = net.Listen("unix", this.Socket)
-	if err != nil {
-		fmt.Printf("failed to start listen socket: '%s'\n", err.Error())
-		return 1
+	if len(this.JsonRpcAddress) != 0 {
+		listener, err := this.Listen(this.JsonRpcNetwork, this.JsonRpcAddress)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return 1
+		}
+		listener.JsonRpc = true
+	}
+	if len(this.HttpAddress) != 0 {
+		if !IsTokenRequired(this.HttpNetwork) {
+			fmt.Printf("HTTP endpoint requires TCP address like 'tcp://127.0.0.1:8080'\n")
+			return 1
+		}
+		listener, err := this.Listen(this.HttpNetwork, this.HttpAddress)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return 1
+		}
+		listener.Http = true
 	}
-	defer os.Remove(this.Socket)
-	err = rpc.Register(new(ServerRPC))
+	err := rpc.Register(new(ServerRPC))
 	if err != nil {
 		fmt.Printf("failed to register RPC: '%s'\n", err.Error())
 		return 1
 	}
-	this.CmdInput = make(chan int, 1)
+	// requests are served while packages are parsed
+	go this.LoadCache()
 	this.Loop()
 	return 0
 }
 
+// Opens listening socket, prepares token if socket requires it
+func (this *Server) Listen(network, address string) (*ServerListener, error) {
+	if network == "unix" {
+		if err := RemoveStaleSocket(address); err != nil {
+			return nil, err
+		}
+	}
+	if IsTokenRequired(network) && len(this.Token) == 0 {
+		if len(this.TokenFile) == 0 {
+			return nil, errors.New(fmt.Sprintf("listening on '%s' requires -token=<path> option", FormatAddress(network, address)))
+		}
+		var err error
+		this.Token, err = ReadOrCreateTokenFile(this.TokenFile)
+		if err != nil {
+			return nil, errors.New("failed to prepare token file: " + err.Error())
+		}
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, errors.New("failed to start listen socket: " + err.Error())
+	}
+	ret := &ServerListener{
+		Network:  network,
+		Address:  address,
+		Listener: listener,
+	}
+	this.Listeners = append(this.Listeners, ret)
+	if network == "unix" {
+		if err = os.Chmod(address, 0700); err != nil {
+			return nil, errors.New("failed to restrict socket permissions: " + err.Error())
+		}
+	}
+	return ret, nil
+}
+
+// Closes listeners and removes unix sockets
+func (this *Server) CloseListeners() {
+	for _, listener := range this.Listeners {
+		listener.Listener.Close()
+		if listener.Network == "unix" {
+			os.Remove(listener.Address)
+		}
+	}
+}
+
 func (this *Server) Loop() {
-	connInput := make(chan net.Conn, 2)
-	go func() {
-		for {
-			conn, err := this.Listener.Accept()
-			if err != nil {
-				panic(errors.New("Daemon socket connection failure: " + err.Error()))
-			}
-			connInput <- conn
+	connInput := make(chan ServerConn, 2)
+	for _, listener := range this.Listeners {
+		if listener.Http {
+			this.httpServer = &http.Server{Handler: NewHttpHandler(this, this.Token)}
+			go this.ServeHttp(listener)
+		} else {
+			go this.Accept(listener, connInput)
 		}
-	}()
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
 	for {
-		// handle connections or server CMDs (currently one CMD)
+		// handle connections, signals or server CMDs (currently one CMD)
 		select {
 		case conn := <-connInput:
-			rpc.ServeConn(conn)
-			runtime.GC()
+			go this.ServeConn(conn)
+		case sig := <-signals:
+			fmt.Fprintf(os.Stderr, "received signal '%v', shutting down\n", sig)
+			this.Shutdown()
+			return
 		case cmd := <-this.CmdInput:
 			if cmd == CommandCloseDaemon {
+				this.Shutdown()
 				return
 			}
 		}
 	}
 }
 
+func (this *Server) Accept(listener *ServerListener, connInput chan ServerConn) {
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			if this.IsClosing() {
+				return
+			}
+			panic(errors.New("Daemon socket connection failure: " + err.Error()))
+		}
+		go this.Authenticate(ServerConn{conn, listener}, connInput)
+	}
+}
+
+func (this *Server) ServeHttp(listener *ServerListener) {
+	err := this.httpServer.Serve(listener.Listener)
+	if err != nil && !this.IsClosing() {
+		panic(errors.New("Daemon HTTP endpoint failure: " + err.Error()))
+	}
+}
+
+// Passes connection to the loop only if it comes from the same user
+// or has presented valid token
+func (this *Server) Authenticate(conn ServerConn, connInput chan ServerConn) {
+	if conn.Listener.Network == "unix" {
+		if err := CheckPeerCredentials(conn.Conn); err != nil {
+			fmt.Fprintf(os.Stderr, "rejected unix socket connection: %s\n", err.Error())
+			conn.Conn.Close()
+			return
+		}
+	}
+	if IsTokenRequired(conn.Listener.Network) {
+		if err := CheckAuthToken(conn.Conn, this.Token); err != nil {
+			fmt.Fprintf(os.Stderr, "rejected connection from '%s': %s\n", conn.Conn.RemoteAddr(), err.Error())
+			conn.Conn.Close()
+			return
+		}
+	}
+	connInput <- conn
+}
+
+func (this *Server) ServeConn(conn ServerConn) {
+	this.mutex.Lock()
+	if this.closing {
+		this.mutex.Unlock()
+		conn.Conn.Close()
+		return
+	}
+	this.conns[conn.Conn] = true
+	this.mutex.Unlock()
+
+	if conn.Listener.JsonRpc {
+		rpc.ServeCodec(NewJsonRpc2ServerCodec(conn.Conn))
+	} else {
+		rpc.ServeConn(conn.Conn)
+	}
+
+	this.mutex.Lock()
+	delete(this.conns, conn.Conn)
+	this.mutex.Unlock()
+	runtime.GC()
+}
+
+// Stops accepting connections, waits until in-flight requests finished
+// or timeout expired, then closes remaining connections and flushes caches
+func (this *Server) Shutdown() {
+	this.mutex.Lock()
+	this.closing = true
+	this.mutex.Unlock()
+	for _, listener := range this.Listeners {
+		listener.Listener.Close()
+	}
+
+	drained := make(chan bool)
+	go func() {
+		this.requests.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(this.ShutdownTimeout):
+		fmt.Fprintf(os.Stderr, "in-flight requests not finished in %v, closing connections\n", this.ShutdownTimeout)
+	}
+
+	this.mutex.Lock()
+	for conn := range this.conns {
+		conn.Close()
+	}
+	this.mutex.Unlock()
+	if this.httpServer != nil {
+		this.httpServer.Close()
+	}
+	this.FlushCache()
+}
+
+func (this *Server) IsClosing() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.closing
+}
+
+// Registers in-flight request, returns false if daemon is shutting down.
+// Each successful call must be paired with EndRequest().
+func (this *Server) BeginRequest() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.closing {
+		return false
+	}
+	this.requests.Add(1)
+	return true
+}
+
+func (this *Server) EndRequest() {
+	this.requests.Done()
+}
+
 func (this *Server) DropCache() {
-	// Currently does nothing
+	this.Workspaces.DropCaches()
+}
+
+// Saves workspaces with lists of their cached packages, restarted daemon
+// parses the same packages again in LoadCache
+func (this *Server) FlushCache() {
+	if err := SaveWorkspaces(this.Workspaces.SaveCaches()); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save caches: %s\n", err.Error())
+	}
+}
+
+func (this *Server) LoadCache() {
+	saved, err := LoadSavedWorkspaces()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "failed to load caches: %s\n", err.Error())
+		}
+		return
+	}
+	this.Workspaces.RestoreCaches(saved)
 }
 
-func (this *Server) Reindex(file []byte, filePath string, packedContext GoBuildContext, result *IndexerResult) {
+func (this *Server) Reindex(args *ArgsReindex, result *IndexerResult) {
+	if len(args.Content) == 0 {
+		args.Content = []byte(args.Text)
+	}
+	overlays := map[string][]byte{args.Path: args.Content}
+	results := map[string]*IndexerResult{args.Path: result}
+	this.ReindexPackage(args.Context, args.Options, overlays, results, nil)
+}
+
+// Reports partial results after each indexing phase, then final chunk
+// with Done flag set
+func (this *Server) ReindexStream(args *ArgsReindex, onChunk func(chunk *GoStreamChunk)) {
+	if len(args.Content) == 0 {
+		args.Content = []byte(args.Text)
+	}
+	var result IndexerResult
+	overlays := map[string][]byte{args.Path: args.Content}
+	results := map[string]*IndexerResult{args.Path: &result}
+	this.ReindexPackage(args.Context, args.Options, overlays, results, func(phase string, path string, chunk *IndexerResult) {
+		onChunk(&GoStreamChunk{Phase: phase, Result: chunk})
+	})
+	onChunk(&GoStreamChunk{Result: &IndexerResult{InPanic: result.InPanic}, Done: true})
+}
+
+// Groups files by package and indexes each package in single pass
+func (this *Server) ReindexBatch(args *ArgsReindexBatch, reply *ReplyReindexBatch) {
+	reply.Results = make(map[string]*IndexerResult)
+	groups := make(map[string]map[string][]byte)
+	for _, file := range args.Files {
+		content := file.Content
+		if len(content) == 0 {
+			content = []byte(file.Text)
+		}
+		reply.Results[file.Path] = new(IndexerResult)
+		key := filepath.Dir(file.Path) + "\x00" + ParsePackageName(file.Path, content)
+		if groups[key] == nil {
+			groups[key] = make(map[string][]byte)
+		}
+		groups[key][file.Path] = content
+	}
+	for _, overlays := range groups {
+		results := make(map[string]*IndexerResult)
+		for path := range overlays {
+			results[path] = reply.Results[path]
+		}
+		this.ReindexPackage(args.Context, args.Options, overlays, results, nil)
+	}
+}
+
+// Indexes files of the same package, isolates panics in the indexer
+func (this *Server) ReindexPackage(context GoBuildContext, options IndexerOptions, overlays map[string][]byte, results map[string]*IndexerResult,
+	onPhase func(phase string, path string, chunk *IndexerResult)) {
 	defer func() {
 		if err := recover(); err != nil {
 			PrintBacktrace(err)
-			result.InPanic = true
+			for _, result := range results {
+				result.InPanic = true
+			}
 			this.DropCache()
+			NewCrashReport(err, context, options, overlays).SaveAndPrint()
 		}
 	}()
+	if len(context.GOOS) == 0 {
+		// JSON clients may omit build context
+		context = PackGoBuildContext(&build.Default)
+	}
+	var anyPath string
+	for anyPath = range overlays {
+		break
+	}
+	indexer := this.NewIndexer(context, options, anyPath)
+	indexer.onPhase = onPhase
+	indexer.ReindexFiles(overlays, results)
+}
+
+// Creates indexer with build context and cache of workspace the file
+// belongs to, registered workspace settings override context sent with
+// request
+func (this *Server) NewIndexer(context GoBuildContext, options IndexerOptions, path string) *PackageIndexer {
+	workspace := this.Workspaces.Find(path, context)
 	indexer := new(PackageIndexer)
-	indexer.context = UnpackGoBuildContext(&packedContext)
-	indexer.result = result
-	indexer.Reindex(filePath, file)
+	indexer.context = UnpackGoBuildContext(&workspace.Context)
+	indexer.cache = workspace.Cache
+	indexer.options = options
+	indexer.workspaceRoot = workspace.Root
+	return indexer
+}
+
+// Runs query about the file, e.g. definition lookup. Panic in the indexer
+// is reported as error and saved as crash report with query name.
+func (this *Server) QueryPackage(name string, args *ArgsPosition, query func(indexer *PackageIndexer)) (err error) {
+	context := args.Context
+	if len(context.GOOS) == 0 {
+		context = PackGoBuildContext(&build.Default)
+	}
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			PrintBacktrace(panicErr)
+			this.DropCache()
+			report := NewCrashReport(panicErr, context, IndexerOptions{}, map[string][]byte{args.Path: args.Content})
+			report.Query = name
+			report.Offset = args.Offset
+			report.SaveAndPrint()
+			err = errors.New(fmt.Sprintf("indexer failed: %v", panicErr))
+		}
+	}()
+	query(this.NewIndexer(context, IndexerOptions{}, args.Path))
+	return nil
+}
+
+func (this *Server) FindDefinition(args *ArgsPosition, reply *ReplyDefinition) error {
+	if len(args.Content) == 0 {
+		args.Content = []byte(args.Text)
+	}
+	return this.QueryPackage("definition", args, func(indexer *PackageIndexer) {
+		definition, err := indexer.FindDefinition(args.Path, args.Content, args.Offset)
+		if err != nil {
+			reply.Reason = err.Error()
+			return
+		}
+		reply.Found = true
+		reply.Definition = definition
+	})
+}
+
+func (this *Server) FindReferences(args *ArgsReferences, reply *ReplyReferences) error {
+	if len(args.Content) == 0 {
+		args.Content = []byte(args.Text)
+	}
+	return this.QueryPackage("references", &args.ArgsPosition, func(indexer *PackageIndexer) {
+		definition, references, err := indexer.FindReferences(args.Path, args.Content, args.Offset, args.WithDecl)
+		if err != nil {
+			reply.Reason = err.Error()
+			return
+		}
+		reply.Found = true
+		reply.Definition = definition
+		reply.References = references
+	})
+}
+
+func (this *Server) FindHover(args *ArgsPosition, reply *ReplyHover) error {
+	if len(args.Content) == 0 {
+		args.Content = []byte(args.Text)
+	}
+	return this.QueryPackage("hover", args, func(indexer *PackageIndexer) {
+		hover, err := indexer.FindHover(args.Path, args.Content, args.Offset)
+		if err != nil {
+			reply.Reason = err.Error()
+			return
+		}
+		reply.Found = true
+		reply.Hover = hover
+	})
+}
+
+func (this *Server) Complete(args *ArgsPosition, reply *ReplyComplete) error {
+	if len(args.Content) == 0 {
+		args.Content = []byte(args.Text)
+	}
+	return this.QueryPackage("complete", args, func(indexer *PackageIndexer) {
+		start, candidates, err := indexer.Complete(args.Path, args.Content, args.Offset)
+		if err != nil {
+			reply.Reason = err.Error()
+			return
+		}
+		reply.Start = start
+		reply.Candidates = candidates
+	})
 }
 
 func (this *Server) Close() {
-	this.CmdInput <- CommandCloseDaemon
+	select {
+	case this.CmdInput <- CommandCloseDaemon:
+	default:
+		// close command already queued
+	}
+}
+
+// Removes socket left by crashed or killed daemon. Fails if another daemon
+// still accepts connections or if path is not a socket.
+func RemoveStaleSocket(socket string) error {
+	info, err := os.Lstat(socket)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.New(fmt.Sprintf("'%s' already exists and is not a socket", socket))
+	}
+	conn, err := net.DialTimeout("unix", socket, STALE_SOCKET_DIAL_TIMEOUT)
+	if err == nil {
+		conn.Close()
+		return errors.New(fmt.Sprintf("another daemon already listens unix socket '%s'", socket))
+	}
+	return os.Remove(socket)
 }
@@ -13,21 +13,250 @@ type ArgsReindex struct {
 	Content []byte
 	Path    string
 	Context GoBuildContext
+	Options IndexerOptions
+	Text    string // alternative to Content for JSON-RPC clients, which would send bytes as base64
 }
 
 func (r *ServerRPC) Reindex(args *ArgsReindex, result *IndexerResult) error {
-	g_app.Server.Reindex(args.Content, args.Path, args.Context, result)
+	if !g_app.Server.BeginRequest() {
+		return ErrShuttingDown
+	}
+	defer g_app.Server.EndRequest()
+	g_app.Server.Reindex(args, result)
 	return nil
 }
 
-func ClientReindex(client *rpc.Client, content []byte, path string, context GoBuildContext) IndexerResult {
-	args := &ArgsReindex{content, path, context}
+func ClientReindex(client *rpc.Client, content []byte, path string, context GoBuildContext, options IndexerOptions) IndexerResult {
+	result, err := CallReindex(client, content, path, context, options)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// Same as ClientReindex, but reports RPC failure instead of panic
+func CallReindex(client *rpc.Client, content []byte, path string, context GoBuildContext, options IndexerOptions) (IndexerResult, error) {
+	args := &ArgsReindex{content, path, context, options, ""}
 	var result IndexerResult
 	err := client.Call("ServerRPC.Reindex", args, &result)
+	return result, err
+}
+
+// RPC for streaming highlight: client starts stream, then polls chunks
+// until one with Done flag. Each poll blocks until next phase completes.
+
+type ReplyStartStream struct {
+	StreamId int
+}
+
+type ArgsNextChunk struct {
+	StreamId int
+}
+
+func (r *ServerRPC) StartStream(args *ArgsReindex, reply *ReplyStartStream) error {
+	if !g_app.Server.BeginRequest() {
+		return ErrShuttingDown
+	}
+	stream := g_app.Server.Streams.Open()
+	reply.StreamId = stream.Id
+	go func() {
+		defer g_app.Server.EndRequest()
+		g_app.Server.ReindexStream(args, stream.Push)
+	}()
+	return nil
+}
+
+func (r *ServerRPC) NextChunk(args *ArgsNextChunk, reply *GoStreamChunk) error {
+	return g_app.Server.Streams.Next(args.StreamId, reply)
+}
+
+// Calls onChunk for each phase result, returns after last chunk
+func ClientReindexStream(client *rpc.Client, content []byte, path string, context GoBuildContext, options IndexerOptions, onChunk func(chunk *GoStreamChunk)) {
+	if err := CallReindexStream(client, content, path, context, options, onChunk); err != nil {
+		panic(err)
+	}
+}
+
+// Same as ClientReindexStream, but reports RPC failure instead of panic
+func CallReindexStream(client *rpc.Client, content []byte, path string, context GoBuildContext, options IndexerOptions, onChunk func(chunk *GoStreamChunk)) error {
+	args := &ArgsReindex{content, path, context, options, ""}
+	var reply ReplyStartStream
+	if err := client.Call("ServerRPC.StartStream", args, &reply); err != nil {
+		return err
+	}
+	for {
+		var chunk GoStreamChunk
+		if err := client.Call("ServerRPC.NextChunk", &ArgsNextChunk{reply.StreamId}, &chunk); err != nil {
+			return err
+		}
+		onChunk(&chunk)
+		if chunk.Done {
+			return nil
+		}
+	}
+}
+
+// RPC for batch highlight
+
+type ArgsBatchFile struct {
+	Path    string
+	Content []byte
+	Text    string // alternative to Content for JSON-RPC clients
+}
+
+type ArgsReindexBatch struct {
+	Files   []ArgsBatchFile
+	Context GoBuildContext
+	Options IndexerOptions
+}
+
+type ReplyReindexBatch struct {
+	Results map[string]*IndexerResult // keyed by file path
+}
+
+func (r *ServerRPC) ReindexBatch(args *ArgsReindexBatch, reply *ReplyReindexBatch) error {
+	if !g_app.Server.BeginRequest() {
+		return ErrShuttingDown
+	}
+	defer g_app.Server.EndRequest()
+	g_app.Server.ReindexBatch(args, reply)
+	return nil
+}
+
+func ClientReindexBatch(client *rpc.Client, files []ArgsBatchFile, context GoBuildContext, options IndexerOptions) map[string]*IndexerResult {
+	results, err := CallReindexBatch(client, files, context, options)
 	if err != nil {
 		panic(err)
 	}
-	return result
+	return results
+}
+
+// Same as ClientReindexBatch, but reports RPC failure instead of panic
+func CallReindexBatch(client *rpc.Client, files []ArgsBatchFile, context GoBuildContext, options IndexerOptions) (map[string]*IndexerResult, error) {
+	args := &ArgsReindexBatch{files, context, options}
+	var reply ReplyReindexBatch
+	err := client.Call("ServerRPC.ReindexBatch", args, &reply)
+	return reply.Results, err
+}
+
+// Arguments of queries about identifier at byte offset of the file
+
+type ArgsPosition struct {
+	Content []byte
+	Path    string
+	Context GoBuildContext
+	Offset  int
+	Text    string // alternative to Content for JSON-RPC clients
+}
+
+// RPC for go to definition
+
+type ReplyDefinition struct {
+	Found      bool
+	Definition GoDefinition
+	Reason     string // why definition is not found
+}
+
+func (r *ServerRPC) FindDefinition(args *ArgsPosition, reply *ReplyDefinition) error {
+	if !g_app.Server.BeginRequest() {
+		return ErrShuttingDown
+	}
+	defer g_app.Server.EndRequest()
+	return g_app.Server.FindDefinition(args, reply)
+}
+
+func ClientFindDefinition(client *rpc.Client, content []byte, path string, context GoBuildContext, offset int) ReplyDefinition {
+	args := &ArgsPosition{content, path, context, offset, ""}
+	var reply ReplyDefinition
+	err := client.Call("ServerRPC.FindDefinition", args, &reply)
+	if err != nil {
+		panic(err)
+	}
+	return reply
+}
+
+// RPC for find references
+
+type ArgsReferences struct {
+	ArgsPosition
+	WithDecl bool // include declaring identifier
+}
+
+type ReplyReferences struct {
+	Found      bool
+	Definition GoDefinition
+	References []GoLocation // sorted by path and offset
+	Reason     string       // why identifier is not resolved
+}
+
+func (r *ServerRPC) FindReferences(args *ArgsReferences, reply *ReplyReferences) error {
+	if !g_app.Server.BeginRequest() {
+		return ErrShuttingDown
+	}
+	defer g_app.Server.EndRequest()
+	return g_app.Server.FindReferences(args, reply)
+}
+
+func ClientFindReferences(client *rpc.Client, content []byte, path string, context GoBuildContext, offset int, withDecl bool) ReplyReferences {
+	args := &ArgsReferences{ArgsPosition{content, path, context, offset, ""}, withDecl}
+	var reply ReplyReferences
+	err := client.Call("ServerRPC.FindReferences", args, &reply)
+	if err != nil {
+		panic(err)
+	}
+	return reply
+}
+
+// RPC for hover information
+
+type ReplyHover struct {
+	Found  bool
+	Hover  GoHover
+	Reason string // why identifier is not resolved
+}
+
+func (r *ServerRPC) FindHover(args *ArgsPosition, reply *ReplyHover) error {
+	if !g_app.Server.BeginRequest() {
+		return ErrShuttingDown
+	}
+	defer g_app.Server.EndRequest()
+	return g_app.Server.FindHover(args, reply)
+}
+
+func ClientFindHover(client *rpc.Client, content []byte, path string, context GoBuildContext, offset int) ReplyHover {
+	args := &ArgsPosition{content, path, context, offset, ""}
+	var reply ReplyHover
+	err := client.Call("ServerRPC.FindHover", args, &reply)
+	if err != nil {
+		panic(err)
+	}
+	return reply
+}
+
+// RPC for completion
+
+type ReplyComplete struct {
+	Start      int // offset of already typed part of name
+	Candidates []GoCandidate
+	Reason     string // why completion failed
+}
+
+func (r *ServerRPC) Complete(args *ArgsPosition, reply *ReplyComplete) error {
+	if !g_app.Server.BeginRequest() {
+		return ErrShuttingDown
+	}
+	defer g_app.Server.EndRequest()
+	return g_app.Server.Complete(args, reply)
+}
+
+func ClientComplete(client *rpc.Client, content []byte, path string, context GoBuildContext, offset int) ReplyComplete {
+	args := &ArgsPosition{content, path, context, offset, ""}
+	var reply ReplyComplete
+	err := client.Call("ServerRPC.Complete", args, &reply)
+	if err != nil {
+		panic(err)
+	}
+	return reply
 }
 
 // RPC for close server
@@ -58,14 +287,16 @@ type ArgsStatus struct {
 	Unused int
 }
 type ReplyStatus struct {
-	Status string
+	Status     string
+	Workspaces []WorkspaceStatus
 }
 
 func (r *ServerRPC) GetStatus(args *ArgsStatus, reply *ReplyStatus) error {
 	reply.Status = "daemon running OK"
+	reply.Workspaces = g_app.Server.Workspaces.Status()
 	return nil
 }
-func ClientStatus(client *rpc.Client) string {
+func ClientStatus(client *rpc.Client) ReplyStatus {
 	args := &ArgsStatus{0}
 	var reply ReplyStatus
 	args.Unused = 0
@@ -73,5 +304,51 @@ func ClientStatus(client *rpc.Client) string {
 	if err != nil {
 		panic(err)
 	}
-	return reply.Status
+	return reply
+}
+
+// RPC for workspace registration
+type ArgsRegisterWorkspace struct {
+	Root     string
+	Context  GoBuildContext
+	Settings WorkspaceSettings
+}
+type ReplyRegisterWorkspace struct {
+	Unused int
+}
+
+func (r *ServerRPC) RegisterWorkspace(args *ArgsRegisterWorkspace, reply *ReplyRegisterWorkspace) error {
+	g_app.Server.Workspaces.Register(args.Root, args.Context, args.Settings)
+	return nil
+}
+
+func ClientRegisterWorkspace(client *rpc.Client, root string, context GoBuildContext, settings WorkspaceSettings) {
+	args := &ArgsRegisterWorkspace{root, context, settings}
+	var reply ReplyRegisterWorkspace
+	err := client.Call("ServerRPC.RegisterWorkspace", args, &reply)
+	if err != nil {
+		panic(err)
+	}
+}
+
+type ArgsUnregisterWorkspace struct {
+	Root string
+}
+type ReplyUnregisterWorkspace struct {
+	Found bool
+}
+
+func (r *ServerRPC) UnregisterWorkspace(args *ArgsUnregisterWorkspace, reply *ReplyUnregisterWorkspace) error {
+	reply.Found = g_app.Server.Workspaces.Unregister(args.Root)
+	return nil
+}
+
+func ClientUnregisterWorkspace(client *rpc.Client, root string) bool {
+	args := &ArgsUnregisterWorkspace{root}
+	var reply ReplyUnregisterWorkspace
+	err := client.Call("ServerRPC.UnregisterWorkspace", args, &reply)
+	if err != nil {
+		panic(err)
+	}
+	return reply.Found
 }
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// One line of `gosemki session` input
+type SessionRequest struct {
+	Id       *json.RawMessage `json:"id,omitempty"`
+	Command  string           `json:"command"`
+	Path     string           `json:"path,omitempty"`
+	Content  *string          `json:"content,omitempty"` // file is read from disk if missed
+	From     *int             `json:"from,omitempty"`
+	To       *int             `json:"to,omitempty"`
+	Root     string           `json:"root,omitempty"`
+	Name     string           `json:"name,omitempty"`
+	Files    []SessionFile    `json:"files,omitempty"`    // for batch command
+	Stream   bool             `json:"stream,omitempty"`   // send chunk after each phase
+	Sections []string         `json:"sections,omitempty"` // all sections if missed
+	Encoding string           `json:"encoding,omitempty"` // bytes if missed
+	Offset   *int             `json:"offset,omitempty"`   // for queries about identifier
+	Decl     bool             `json:"decl,omitempty"`     // include declaration into references
+}
+
+type SessionFile struct {
+	Path    string  `json:"path"`
+	Content *string `json:"content,omitempty"`
+}
+
+// One line of `gosemki session` output, has the same id as request
+type SessionResponse struct {
+	Id     *json.RawMessage `json:"id,omitempty"`
+	Phase  string           `json:"phase,omitempty"` // set for intermediate chunks of stream
+	Result interface{}      `json:"result,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// Serves newline-delimited JSON requests from stdin over single daemon
+// connection, so editor plugin can keep one child process
+func (this *Client) ExecSession() int {
+	reader := bufio.NewReader(os.Stdin)
+	writer := bufio.NewWriter(os.Stdout)
+	context := PackGoBuildContext(&build.Default)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) != 0 {
+			response := this.HandleSessionLine(line, context, func(chunk *SessionResponse) {
+				WriteSessionResponse(writer, chunk)
+			})
+			WriteSessionResponse(writer, &response)
+		}
+		if err == io.EOF {
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gosemki session: %s\n", err.Error())
+			return 1
+		}
+	}
+}
+
+func WriteSessionResponse(writer *bufio.Writer, response *SessionResponse) {
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		jsonBytes, _ = json.Marshal(&SessionResponse{Id: response.Id, Error: err.Error()})
+	}
+	writer.Write(jsonBytes)
+	writer.WriteByte('\n')
+	writer.Flush()
+}
+
+// Intermediate chunks of streamed request are passed to emit, final
+// response is returned
+func (this *Client) HandleSessionLine(line []byte, context GoBuildContext, emit func(chunk *SessionResponse)) (response SessionResponse) {
+	var request SessionRequest
+	if err := json.Unmarshal(line, &request); err != nil {
+		response.Error = "malformed request: " + err.Error()
+		return
+	}
+	response.Id = request.Id
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			response.Result = nil
+			response.Error = fmt.Sprintf("%v", panicErr)
+		}
+	}()
+	result, err := this.HandleSessionRequest(&request, context, emit)
+	if err != nil && IsConnectionError(err) {
+		// daemon was restarted or closed, reconnect once and retry
+		this.RpcClient.Close()
+		if err = this.Connect(); err == nil {
+			result, err = this.HandleSessionRequest(&request, context, emit)
+		}
+	}
+	if err != nil {
+		response.Error = err.Error()
+		return
+	}
+	response.Result = result
+	return
+}
+
+func (this *Client) HandleSessionRequest(request *SessionRequest, context GoBuildContext, emit func(chunk *SessionResponse)) (interface{}, error) {
+	switch request.Command {
+	case "highlight", "outline", "errors":
+		content, path, err := ReadSessionFile(request)
+		if err != nil {
+			return nil, err
+		}
+		if err = CheckPositionEncoding(request.Encoding); err != nil {
+			return nil, err
+		}
+		options := IndexerOptions{Encoding: request.Encoding}
+		switch request.Command {
+		case "outline":
+			options.Sections = []string{SectionOutline}
+		case "errors":
+			options.Sections = []string{SectionErrors}
+		default:
+			if options.Sections, err = ParseSections(strings.Join(request.Sections, ",")); err != nil {
+				return nil, err
+			}
+		}
+		if request.From != nil || request.To != nil {
+			options.Viewport = &GoViewport{FromOffset: 0, ToOffset: len(content)}
+			if request.From != nil {
+				options.Viewport.FromOffset = *request.From
+			}
+			if request.To != nil {
+				options.Viewport.ToOffset = *request.To
+			}
+		}
+		var result IndexerResult
+		if request.Stream {
+			err = CallReindexStream(this.RpcClient, content, path, context, options, func(chunk *GoStreamChunk) {
+				result.Append(chunk.Result)
+				if !chunk.Done {
+					emit(&SessionResponse{Id: request.Id, Phase: chunk.Phase, Result: SelectSessionResult(request.Command, chunk.Result)})
+				}
+			})
+		} else {
+			result, err = CallReindex(this.RpcClient, content, path, context, options)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return SelectSessionResult(request.Command, &result), nil
+	case "batch":
+		files := make([]ArgsBatchFile, 0, len(request.Files))
+		for _, file := range request.Files {
+			content, path, err := ReadSessionFile(&SessionRequest{Path: file.Path, Content: file.Content})
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, ArgsBatchFile{Path: path, Content: content})
+		}
+		var reply ReplyReindexBatch
+		sections, err := ParseSections(strings.Join(request.Sections, ","))
+		if err == nil {
+			err = CheckPositionEncoding(request.Encoding)
+		}
+		if err != nil {
+			return nil, err
+		}
+		options := IndexerOptions{Sections: sections, Encoding: request.Encoding}
+		err = this.RpcClient.Call("ServerRPC.ReindexBatch", &ArgsReindexBatch{files, context, options}, &reply)
+		return reply.Results, err
+	case "definition", "references", "hover", "complete":
+		content, path, err := ReadSessionFile(request)
+		if err != nil {
+			return nil, err
+		}
+		if request.Offset == nil {
+			return nil, errors.New("missed 'offset' field")
+		}
+		args := ArgsPosition{content, path, context, *request.Offset, ""}
+		if request.Command == "references" {
+			var reply ReplyReferences
+			err = this.RpcClient.Call("ServerRPC.FindReferences", &ArgsReferences{args, request.Decl}, &reply)
+			return reply, err
+		}
+		if request.Command == "hover" {
+			var reply ReplyHover
+			err = this.RpcClient.Call("ServerRPC.FindHover", &args, &reply)
+			return reply, err
+		}
+		if request.Command == "complete" {
+			var reply ReplyComplete
+			err = this.RpcClient.Call("ServerRPC.Complete", &args, &reply)
+			return reply, err
+		}
+		var reply ReplyDefinition
+		err = this.RpcClient.Call("ServerRPC.FindDefinition", &args, &reply)
+		return reply, err
+	case "status":
+		var reply ReplyStatus
+		err := this.RpcClient.Call("ServerRPC.GetStatus", &ArgsStatus{0}, &reply)
+		return reply, err
+	case "workspace_add", "workspace_remove":
+		if len(request.Root) == 0 {
+			return nil, errors.New("missed 'root' field")
+		}
+		root, err := filepath.Abs(request.Root)
+		if err != nil {
+			return nil, err
+		}
+		if request.Command == "workspace_remove" {
+			var reply ReplyUnregisterWorkspace
+			err = this.RpcClient.Call("ServerRPC.UnregisterWorkspace", &ArgsUnregisterWorkspace{root}, &reply)
+			return reply, err
+		}
+		settings := WorkspaceSettings{Name: request.Name}
+		if len(settings.Name) == 0 {
+			settings.Name = filepath.Base(root)
+		}
+		var reply ReplyRegisterWorkspace
+		err = this.RpcClient.Call("ServerRPC.RegisterWorkspace", &ArgsRegisterWorkspace{root, context, settings}, &reply)
+		return reply, err
+	}
+	return nil, errors.New(fmt.Sprintf("unknown command '%s'", request.Command))
+}
+
+func SelectSessionResult(command string, result *IndexerResult) interface{} {
+	switch command {
+	case "outline":
+		return result.Outline
+	case "errors":
+		return result.Errors
+	}
+	return *result
+}
+
+func ReadSessionFile(request *SessionRequest) ([]byte, string, error) {
+	if len(request.Path) == 0 {
+		return nil, "", errors.New("missed 'path' field")
+	}
+	path, err := filepath.Abs(request.Path)
+	if err != nil {
+		return nil, "", err
+	}
+	if request.Content != nil {
+		return []byte(*request.Content), path, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	return content, path, err
+}
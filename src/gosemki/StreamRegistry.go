@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// stream is dropped if client does not poll it for this time
+	STREAM_EXPIRE_TIMEOUT = 30 * time.Second
+	// enough for all phases, so indexer never waits for client
+	STREAM_CHUNKS_CAPACITY = 8
+)
+
+type ResultStream struct {
+	Id     int
+	Chunks chan *GoStreamChunk
+	expire *time.Timer
+}
+
+// Streams started by ServerRPC.StartStream and polled by ServerRPC.NextChunk
+type StreamRegistry struct {
+	mutex   sync.Mutex
+	lastId  int
+	streams map[int]*ResultStream
+}
+
+func NewStreamRegistry() *StreamRegistry {
+	ret := new(StreamRegistry)
+	ret.streams = make(map[int]*ResultStream)
+	return ret
+}
+
+func (this *StreamRegistry) Open() *ResultStream {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.lastId++
+	stream := &ResultStream{
+		Id:     this.lastId,
+		Chunks: make(chan *GoStreamChunk, STREAM_CHUNKS_CAPACITY),
+	}
+	stream.expire = time.AfterFunc(STREAM_EXPIRE_TIMEOUT, func() {
+		this.Remove(stream.Id)
+	})
+	this.streams[stream.Id] = stream
+	return stream
+}
+
+func (this *StreamRegistry) Remove(id int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	delete(this.streams, id)
+}
+
+// Waits for next chunk of stream, removes stream after the last chunk
+func (this *StreamRegistry) Next(id int, chunk *GoStreamChunk) error {
+	this.mutex.Lock()
+	stream := this.streams[id]
+	this.mutex.Unlock()
+	if stream == nil {
+		return errors.New(fmt.Sprintf("unknown or expired stream %d", id))
+	}
+	stream.expire.Reset(STREAM_EXPIRE_TIMEOUT)
+	*chunk = *<-stream.Chunks
+	if chunk.Done {
+		stream.expire.Stop()
+		this.Remove(id)
+	}
+	return nil
+}
+
+func (this *ResultStream) Push(chunk *GoStreamChunk) {
+	select {
+	case this.Chunks <- chunk:
+	default:
+		// stream has more chunks than expected, client would never read them
+		panic(errors.New("result stream overflow"))
+	}
+}
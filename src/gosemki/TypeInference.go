@@ -0,0 +1,556 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// Limits recursion of inference through declarations and embedded types
+const TYPE_INFER_MAX_DEPTH = 16
+
+// Type expression with package it belongs to, identifiers of expression
+// are resolved in that package. Nil Pkg means indexed package.
+type TypeRef struct {
+	Expr ast.Expr
+	Pkg  *CachedPackage
+}
+
+// Field or method of type
+type TypeMember struct {
+	Name string
+	Kind GoKind  // GoKindField or GoKindFunc
+	Type TypeRef // func type for methods
+	// declared object, its Decl is *ast.Field or *ast.FuncDecl
+	Obj *ast.Object
+}
+
+// Infers types of expressions from declarations, without type checking.
+// Good enough for completion and hover: follows variables, calls, fields,
+// methods, composite literals and conversions.
+type TypeInferrer struct {
+	indexer *PackageIndexer
+	depth   int
+}
+
+func NewTypeInferrer(indexer *PackageIndexer) *TypeInferrer {
+	return &TypeInferrer{indexer: indexer}
+}
+
+func (this *TypeInferrer) Enter() bool {
+	this.depth++
+	return this.depth <= TYPE_INFER_MAX_DEPTH
+}
+
+func (this *TypeInferrer) Leave() {
+	this.depth--
+}
+
+// Type of variable, constant, field or func declared in the package
+func (this *TypeInferrer) InferObject(obj *ast.Object, pkg *CachedPackage) (TypeRef, bool) {
+	if !this.Enter() {
+		return TypeRef{}, false
+	}
+	defer this.Leave()
+	switch decl := obj.Decl.(type) {
+	case *ast.ValueSpec:
+		index := GetNameIndex(decl.Names, obj)
+		typeExpr, valueExpr := GetValueSpecExprs(FindGenDecl(this.GetFiles(pkg), decl), decl, obj.Name)
+		if typeExpr != nil {
+			return TypeRef{typeExpr, pkg}, true
+		}
+		if valueExpr != nil {
+			return this.InferExpr(valueExpr, pkg)
+		}
+		if len(decl.Values) == 1 {
+			return this.InferResult(decl.Values[0], index, pkg)
+		}
+	case *ast.Field:
+		if ellipsis, ok := decl.Type.(*ast.Ellipsis); ok {
+			return TypeRef{&ast.ArrayType{Elt: ellipsis.Elt}, pkg}, true
+		}
+		return TypeRef{decl.Type, pkg}, true
+	case *ast.AssignStmt:
+		index := GetNameIndex(decl.Lhs, obj)
+		if len(decl.Lhs) == len(decl.Rhs) {
+			return this.InferExpr(decl.Rhs[index], pkg)
+		}
+		if len(decl.Rhs) == 1 {
+			return this.InferResult(decl.Rhs[0], index, pkg)
+		}
+	case *ast.FuncDecl:
+		return TypeRef{decl.Type, pkg}, true
+	}
+	return TypeRef{}, false
+}
+
+// Returns index of identifier declaring the object
+func GetNameIndex(names interface{}, obj *ast.Object) int {
+	switch list := names.(type) {
+	case []*ast.Ident:
+		for i, name := range list {
+			if name.Obj == obj || name.Name == obj.Name {
+				return i
+			}
+		}
+	case []ast.Expr:
+		for i, expr := range list {
+			if name, ok := expr.(*ast.Ident); ok && (name.Obj == obj || name.Name == obj.Name) {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+// Type of index-th value of multi-value expression, like call, type
+// assertion, map index, channel receive or range clause
+func (this *TypeInferrer) InferResult(expr ast.Expr, index int, pkg *CachedPackage) (TypeRef, bool) {
+	boolType := TypeRef{ast.NewIdent("bool"), nil}
+	switch x := expr.(type) {
+	case *ast.CallExpr:
+		return this.InferCall(x, index, pkg)
+	case *ast.TypeAssertExpr, *ast.IndexExpr:
+		if index == 1 {
+			return boolType, true
+		}
+	case *ast.UnaryExpr:
+		if x.Op == token.RANGE {
+			return this.InferRange(x.X, index, pkg)
+		}
+		if x.Op == token.ARROW && index == 1 {
+			return boolType, true
+		}
+	}
+	if index != 0 {
+		return TypeRef{}, false
+	}
+	return this.InferExpr(expr, pkg)
+}
+
+// Key and value types of range clause
+func (this *TypeInferrer) InferRange(expr ast.Expr, index int, pkg *CachedPackage) (TypeRef, bool) {
+	container, ok := this.InferExpr(expr, pkg)
+	if !ok {
+		return TypeRef{}, false
+	}
+	underlying := this.Underlying(this.Deref(container))
+	switch x := underlying.Expr.(type) {
+	case *ast.ArrayType:
+		if index == 0 {
+			return TypeRef{ast.NewIdent("int"), nil}, true
+		}
+		return TypeRef{x.Elt, underlying.Pkg}, true
+	case *ast.MapType:
+		if index == 0 {
+			return TypeRef{x.Key, underlying.Pkg}, true
+		}
+		return TypeRef{x.Value, underlying.Pkg}, true
+	case *ast.ChanType:
+		return TypeRef{x.Value, underlying.Pkg}, index == 0
+	case *ast.Ident:
+		if x.Name == "string" {
+			if index == 0 {
+				return TypeRef{ast.NewIdent("int"), nil}, true
+			}
+			return TypeRef{ast.NewIdent("rune"), nil}, true
+		}
+	}
+	return TypeRef{}, false
+}
+
+func (this *TypeInferrer) InferExpr(expr ast.Expr, pkg *CachedPackage) (TypeRef, bool) {
+	if !this.Enter() {
+		return TypeRef{}, false
+	}
+	defer this.Leave()
+	switch x := expr.(type) {
+	case *ast.Ident:
+		if x.Obj == nil || x.Obj.Kind == ast.Typ || x.Obj.Kind == ast.Pkg {
+			return TypeRef{}, false
+		}
+		return this.InferObject(x.Obj, pkg)
+	case *ast.BasicLit:
+		return TypeRef{ast.NewIdent(GetLiteralTypeName(x.Kind)), nil}, true
+	case *ast.CompositeLit:
+		return TypeRef{x.Type, pkg}, x.Type != nil
+	case *ast.FuncLit:
+		return TypeRef{x.Type, pkg}, true
+	case *ast.ParenExpr:
+		return this.InferExpr(x.X, pkg)
+	case *ast.UnaryExpr:
+		operand, ok := this.InferExpr(x.X, pkg)
+		if !ok {
+			break
+		}
+		switch x.Op {
+		case token.AND:
+			return TypeRef{&ast.StarExpr{X: operand.Expr}, operand.Pkg}, true
+		case token.ARROW:
+			if channel, ok := this.Underlying(operand).Expr.(*ast.ChanType); ok {
+				return TypeRef{channel.Value, this.Underlying(operand).Pkg}, true
+			}
+			return TypeRef{}, false
+		}
+		return operand, true
+	case *ast.StarExpr:
+		operand, ok := this.InferExpr(x.X, pkg)
+		if !ok {
+			break
+		}
+		return this.Deref(operand), true
+	case *ast.BinaryExpr:
+		switch x.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ, token.LAND, token.LOR:
+			return TypeRef{ast.NewIdent("bool"), nil}, true
+		}
+		if left, ok := this.InferExpr(x.X, pkg); ok {
+			return left, true
+		}
+		return this.InferExpr(x.Y, pkg)
+	case *ast.CallExpr:
+		return this.InferCall(x, 0, pkg)
+	case *ast.SelectorExpr:
+		if selected := this.LookupSelectedPackage(x, pkg); selected != nil {
+			if obj := selected.Scope.Lookup(x.Sel.Name); obj != nil {
+				return this.InferObject(obj, selected)
+			}
+			return TypeRef{}, false
+		}
+		operand, ok := this.InferExpr(x.X, pkg)
+		if !ok {
+			break
+		}
+		if member := FindMember(this.CollectMembers(operand), x.Sel.Name); member != nil {
+			return member.Type, true
+		}
+	case *ast.IndexExpr:
+		operand, ok := this.InferExpr(x.X, pkg)
+		if !ok {
+			break
+		}
+		underlying := this.Underlying(this.Deref(operand))
+		switch container := underlying.Expr.(type) {
+		case *ast.ArrayType:
+			return TypeRef{container.Elt, underlying.Pkg}, true
+		case *ast.MapType:
+			return TypeRef{container.Value, underlying.Pkg}, true
+		case *ast.Ident:
+			if container.Name == "string" {
+				return TypeRef{ast.NewIdent("byte"), nil}, true
+			}
+		}
+	case *ast.SliceExpr:
+		return this.InferExpr(x.X, pkg)
+	case *ast.TypeAssertExpr:
+		return TypeRef{x.Type, pkg}, x.Type != nil
+	}
+	return TypeRef{}, false
+}
+
+// Type of index-th result of call, conversion or builtin func
+func (this *TypeInferrer) InferCall(call *ast.CallExpr, index int, pkg *CachedPackage) (TypeRef, bool) {
+	fun := unparen(call.Fun)
+	if name, ok := fun.(*ast.Ident); ok && this.IsBuiltin(name, pkg) && index == 0 {
+		switch name.Name {
+		case "new":
+			if len(call.Args) == 1 {
+				return TypeRef{&ast.StarExpr{X: call.Args[0]}, pkg}, true
+			}
+		case "make":
+			if len(call.Args) != 0 {
+				return TypeRef{call.Args[0], pkg}, true
+			}
+		case "append":
+			if len(call.Args) != 0 {
+				return this.InferExpr(call.Args[0], pkg)
+			}
+		case "len", "cap", "copy":
+			return TypeRef{ast.NewIdent("int"), nil}, true
+		case "recover":
+			return TypeRef{&ast.InterfaceType{Methods: &ast.FieldList{}}, nil}, true
+		default:
+			if _, isType := this.ResolveTypeName(TypeRef{name, pkg}); isType {
+				// conversion to predeclared type
+				return TypeRef{name, pkg}, true
+			}
+		}
+		return TypeRef{}, false
+	}
+	if this.IsTypeExpr(fun, pkg) {
+		return TypeRef{fun, pkg}, index == 0
+	}
+	funType, ok := this.InferExpr(fun, pkg)
+	if !ok {
+		return TypeRef{}, false
+	}
+	underlying := this.Underlying(funType)
+	signature, ok := underlying.Expr.(*ast.FuncType)
+	if !ok || signature.Results == nil {
+		return TypeRef{}, false
+	}
+	for _, field := range signature.Results.List {
+		count := len(field.Names)
+		if count == 0 {
+			count = 1
+		}
+		if index < count {
+			return TypeRef{field.Type, underlying.Pkg}, true
+		}
+		index -= count
+	}
+	return TypeRef{}, false
+}
+
+// Detects conversions like T(x), []byte(s) or pkg.T(x)
+func (this *TypeInferrer) IsTypeExpr(expr ast.Expr, pkg *CachedPackage) bool {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return x.Obj != nil && x.Obj.Kind == ast.Typ
+	case *ast.SelectorExpr:
+		if selected := this.LookupSelectedPackage(x, pkg); selected != nil {
+			obj := selected.Scope.Lookup(x.Sel.Name)
+			return obj != nil && obj.Kind == ast.Typ
+		}
+	case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.StarExpr, *ast.InterfaceType, *ast.StructType:
+		return true
+	}
+	return false
+}
+
+// Predeclared identifiers are resolved to injected builtin declarations in
+// indexed package and are not resolved at all in imported packages
+func (this *TypeInferrer) IsBuiltin(name *ast.Ident, pkg *CachedPackage) bool {
+	if name.Obj == nil {
+		return pkg != nil
+	}
+	return pkg == nil && this.indexer.IsBuiltinObject(name.Obj)
+}
+
+// Finds package of qualified identifier like io.Reader, which can be used
+// in indexed package or in imported one
+func (this *TypeInferrer) LookupSelectedPackage(selector *ast.SelectorExpr, pkg *CachedPackage) *CachedPackage {
+	if pkg == nil {
+		return this.indexer.GetSelectedPackage(selector)
+	}
+	// imported packages are resolved without importer, so their imports
+	// are found by file of selector
+	name, ok := selector.X.(*ast.Ident)
+	if !ok || name.Obj != nil {
+		return nil
+	}
+	tokenFile := pkg.Fset.File(selector.Pos())
+	for _, file := range pkg.Files {
+		if pkg.Fset.File(file.Pos()) != tokenFile {
+			continue
+		}
+		for _, spec := range file.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			importName := path[strings.LastIndex(path, "/")+1:]
+			if spec.Name != nil {
+				importName = spec.Name.Name
+			}
+			if importName != name.Name {
+				continue
+			}
+			imported, err := this.indexer.cache.Import(&this.indexer.context, path, pkg.Dir)
+			if err != nil {
+				return nil
+			}
+			return imported
+		}
+	}
+	return nil
+}
+
+// Finds declaration of named type
+func (this *TypeInferrer) ResolveTypeName(ref TypeRef) (TypeRef, bool) {
+	var obj *ast.Object
+	pkg := ref.Pkg
+	switch x := ref.Expr.(type) {
+	case *ast.Ident:
+		obj = x.Obj
+		if obj == nil || (pkg == nil && this.indexer.IsBuiltinObject(obj)) {
+			// predeclared types are declared in indexed package only
+			obj = this.indexer.packageScope.Lookup(x.Name)
+			pkg = nil
+		}
+	case *ast.SelectorExpr:
+		if pkg = this.LookupSelectedPackage(x, ref.Pkg); pkg != nil {
+			obj = pkg.Scope.Lookup(x.Sel.Name)
+		}
+	case *ast.IndexExpr:
+		// instantiated generic type
+		return this.ResolveTypeName(TypeRef{x.X, ref.Pkg})
+	}
+	if obj == nil || obj.Kind != ast.Typ {
+		return TypeRef{}, false
+	}
+	spec, ok := obj.Decl.(*ast.TypeSpec)
+	if !ok {
+		return TypeRef{}, false
+	}
+	return TypeRef{spec.Name, pkg}, true
+}
+
+// Follows names of types to their type literals, predeclared types like
+// int are returned as is
+func (this *TypeInferrer) Underlying(ref TypeRef) TypeRef {
+	for i := 0; i < TYPE_INFER_MAX_DEPTH; i++ {
+		if paren, ok := ref.Expr.(*ast.ParenExpr); ok {
+			ref.Expr = paren.X
+			continue
+		}
+		name, ok := this.ResolveTypeName(ref)
+		if !ok {
+			break
+		}
+		spec := name.Expr.(*ast.Ident).Obj.Decl.(*ast.TypeSpec)
+		if underlying, ok := spec.Type.(*ast.Ident); ok && underlying.Name == spec.Name.Name {
+			// builtin declarations like 'type int int'
+			return TypeRef{spec.Name, name.Pkg}
+		}
+		ref = TypeRef{spec.Type, name.Pkg}
+	}
+	return ref
+}
+
+// Same as ast.Unparen, which needs Go 1.22
+func unparen(expr ast.Expr) ast.Expr {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = paren.X
+	}
+}
+
+func (this *TypeInferrer) Deref(ref TypeRef) TypeRef {
+	if pointer, ok := unparen(ref.Expr).(*ast.StarExpr); ok {
+		return TypeRef{pointer.X, ref.Pkg}
+	}
+	return ref
+}
+
+// Lists fields and methods of type, including promoted ones. Members of
+// embedded types follow members of the type itself.
+func (this *TypeInferrer) CollectMembers(ref TypeRef) (members []TypeMember) {
+	if !this.Enter() {
+		return
+	}
+	defer this.Leave()
+	ref = this.Deref(ref)
+	if name, ok := this.ResolveTypeName(ref); ok {
+		typeName := name.Expr.(*ast.Ident).Name
+		for _, file := range this.GetFiles(name.Pkg) {
+			for _, decl := range file.Decls {
+				if funcDecl, ok := decl.(*ast.FuncDecl); ok && GetReceiverTypeName(funcDecl) == typeName {
+					members = append(members, TypeMember{funcDecl.Name.Name, GoKindFunc, TypeRef{funcDecl.Type, name.Pkg}, NewMemberObject(ast.Fun, funcDecl.Name, funcDecl)})
+				}
+			}
+		}
+	}
+	underlying := this.Underlying(ref)
+	var embedded []TypeRef
+	switch x := underlying.Expr.(type) {
+	case *ast.StructType:
+		for _, field := range x.Fields.List {
+			fieldType := TypeRef{field.Type, underlying.Pkg}
+			if len(field.Names) == 0 {
+				if name := GetEmbeddedName(field.Type); name != nil {
+					members = append(members, TypeMember{name.Name, GoKindField, fieldType, NewMemberObject(ast.Var, name, field)})
+				}
+				embedded = append(embedded, fieldType)
+			}
+			for _, name := range field.Names {
+				members = append(members, TypeMember{name.Name, GoKindField, fieldType, NewMemberObject(ast.Var, name, field)})
+			}
+		}
+	case *ast.InterfaceType:
+		for _, method := range x.Methods.List {
+			if len(method.Names) == 0 {
+				embedded = append(embedded, TypeRef{method.Type, underlying.Pkg})
+			}
+			for _, name := range method.Names {
+				members = append(members, TypeMember{name.Name, GoKindFunc, TypeRef{method.Type, underlying.Pkg}, NewMemberObject(ast.Fun, name, method)})
+			}
+		}
+	}
+	for _, embeddedType := range embedded {
+		for _, member := range this.CollectMembers(embeddedType) {
+			if !HasMember(members, member.Name) {
+				members = append(members, member)
+			}
+		}
+	}
+	return
+}
+
+// Parser declares names of fields and interface methods, but not names of
+// methods and embedded fields
+func NewMemberObject(kind ast.ObjKind, name *ast.Ident, decl ast.Node) *ast.Object {
+	if name.Obj != nil && name.Obj.Decl == decl {
+		return name.Obj
+	}
+	obj := ast.NewObj(kind, name.Name)
+	obj.Decl = decl
+	return obj
+}
+
+// Member by name, nil if type has no such member
+func FindMember(members []TypeMember, name string) *TypeMember {
+	for i := range members {
+		if members[i].Name == name {
+			return &members[i]
+		}
+	}
+	return nil
+}
+
+func HasMember(members []TypeMember, name string) bool {
+	return FindMember(members, name) != nil
+}
+
+func (this *TypeInferrer) GetFiles(pkg *CachedPackage) map[string]*ast.File {
+	if pkg == nil {
+		return this.indexer.files
+	}
+	return pkg.Files
+}
+
+// Prints type, names declared in imported package are qualified
+func (this *TypeInferrer) FormatType(ref TypeRef) string {
+	if ref.Pkg == nil {
+		return FormatNode(this.indexer.fset, ref.Expr)
+	}
+	text := FormatNode(ref.Pkg.Fset, ref.Expr)
+	var name *ast.Ident
+	switch x := ref.Expr.(type) {
+	case *ast.Ident:
+		name = x
+	case *ast.StarExpr:
+		name, _ = x.X.(*ast.Ident)
+	}
+	if name != nil && name.Obj != nil && name.Obj.Kind == ast.Typ {
+		qualifier := ref.Pkg.ImportPath[strings.LastIndex(ref.Pkg.ImportPath, "/")+1:]
+		text = strings.Replace(text, name.Name, qualifier+"."+name.Name, 1)
+	}
+	return text
+}
+
+// Default type of untyped literal
+func GetLiteralTypeName(kind token.Token) string {
+	switch kind {
+	case token.INT:
+		return "int"
+	case token.FLOAT:
+		return "float64"
+	case token.IMAG:
+		return "complex128"
+	case token.CHAR:
+		return "rune"
+	}
+	return "string"
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SAVED_WORKSPACES_FILE = "workspaces.json"
+)
+
+type WorkspaceSettings struct {
+	Name              string // shown in daemon status
+	MaxCachedPackages int    // zero means default limit
+}
+
+// Project root with own build context and caches
+type Workspace struct {
+	Root     string
+	Context  GoBuildContext
+	Settings WorkspaceSettings
+	Cache    *PackageCache
+	Requests int
+	LastUsed time.Time
+}
+
+type WorkspaceStatus struct {
+	Root           string
+	Name           string
+	Implicit       bool
+	CachedPackages int
+	Requests       int
+	LastUsed       time.Time
+}
+
+// Workspace with cached packages saved on daemon shutdown
+type SavedWorkspace struct {
+	Root     string // empty for implicit workspace
+	Context  GoBuildContext
+	Settings WorkspaceSettings
+	Packages []SavedPackage
+}
+
+// Registered workspaces plus implicit ones created for files outside
+// of any registered root, one per distinct build context
+type WorkspaceRegistry struct {
+	mutex      sync.Mutex
+	registered map[string]*Workspace
+	implicit   map[string]*Workspace
+}
+
+func NewWorkspaceRegistry() *WorkspaceRegistry {
+	ret := new(WorkspaceRegistry)
+	ret.registered = make(map[string]*Workspace)
+	ret.implicit = make(map[string]*Workspace)
+	return ret
+}
+
+func NewWorkspace(root string, context GoBuildContext, settings WorkspaceSettings) *Workspace {
+	return &Workspace{
+		Root:     root,
+		Context:  context,
+		Settings: settings,
+		Cache:    NewPackageCache(settings.MaxCachedPackages),
+		LastUsed: time.Now(),
+	}
+}
+
+// Registers workspace or replaces settings and drops caches of existing one
+func (this *WorkspaceRegistry) Register(root string, context GoBuildContext, settings WorkspaceSettings) *Workspace {
+	root = filepath.Clean(root)
+	workspace := NewWorkspace(root, context, settings)
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.registered[root] = workspace
+	return workspace
+}
+
+func (this *WorkspaceRegistry) Unregister(root string) bool {
+	root = filepath.Clean(root)
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	_, found := this.registered[root]
+	delete(this.registered, root)
+	return found
+}
+
+// Finds registered workspace with the longest root containing given file,
+// falls back to implicit workspace for given build context
+func (this *WorkspaceRegistry) Find(filePath string, context GoBuildContext) *Workspace {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	var found *Workspace
+	for root, workspace := range this.registered {
+		if IsPathInside(filePath, root) && (found == nil || len(root) > len(found.Root)) {
+			found = workspace
+		}
+	}
+	if found == nil {
+		key := fmt.Sprintf("%#v", context)
+		found = this.implicit[key]
+		if found == nil {
+			found = NewWorkspace("", context, WorkspaceSettings{Name: "implicit"})
+			this.implicit[key] = found
+		}
+	}
+	found.Requests++
+	found.LastUsed = time.Now()
+	return found
+}
+
+func (this *WorkspaceRegistry) DropCaches() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, workspace := range this.registered {
+		workspace.Cache.Clear()
+	}
+	for _, workspace := range this.implicit {
+		workspace.Cache.Clear()
+	}
+}
+
+func (this *WorkspaceRegistry) SaveCaches() []SavedWorkspace {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	var ret []SavedWorkspace
+	for _, workspace := range this.registered {
+		ret = append(ret, workspace.Save())
+	}
+	for _, workspace := range this.implicit {
+		ret = append(ret, workspace.Save())
+	}
+	return ret
+}
+
+// Registers saved workspaces again and parses their cached packages.
+// Workspaces registered meanwhile keep their settings.
+func (this *WorkspaceRegistry) RestoreCaches(saved []SavedWorkspace) {
+	for _, entry := range saved {
+		key := fmt.Sprintf("%#v", entry.Context)
+		this.mutex.Lock()
+		workspaces := this.implicit
+		if len(entry.Root) != 0 {
+			workspaces = this.registered
+			key = entry.Root
+		}
+		workspace := workspaces[key]
+		if workspace == nil {
+			workspace = NewWorkspace(entry.Root, entry.Context, entry.Settings)
+			workspaces[key] = workspace
+		}
+		this.mutex.Unlock()
+		if fmt.Sprintf("%#v", workspace.Context) == fmt.Sprintf("%#v", entry.Context) {
+			context := UnpackGoBuildContext(&entry.Context)
+			workspace.Cache.Restore(&context, entry.Packages)
+		}
+	}
+}
+
+func (this *Workspace) Save() SavedWorkspace {
+	return SavedWorkspace{
+		Root:     this.Root,
+		Context:  this.Context,
+		Settings: this.Settings,
+		Packages: this.Cache.Save(),
+	}
+}
+
+func GetSavedWorkspacesPath() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "gosemki", SAVED_WORKSPACES_FILE)
+}
+
+func SaveWorkspaces(saved []SavedWorkspace) error {
+	path := GetSavedWorkspacesPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	jsonBytes, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, jsonBytes, 0600)
+}
+
+func LoadSavedWorkspaces() ([]SavedWorkspace, error) {
+	content, err := ioutil.ReadFile(GetSavedWorkspacesPath())
+	if err != nil {
+		return nil, err
+	}
+	var saved []SavedWorkspace
+	if err = json.Unmarshal(content, &saved); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to parse saved workspaces: %s", err.Error()))
+	}
+	return saved, nil
+}
+
+// Lists registered workspaces sorted by root, then implicit ones
+func (this *WorkspaceRegistry) Status() []WorkspaceStatus {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	var ret []WorkspaceStatus
+	for _, workspace := range this.registered {
+		ret = append(ret, workspace.Status(false))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Root < ret[j].Root
+	})
+	for _, workspace := range this.implicit {
+		ret = append(ret, workspace.Status(true))
+	}
+	return ret
+}
+
+func (this *Workspace) Status(implicit bool) WorkspaceStatus {
+	return WorkspaceStatus{
+		Root:           this.Root,
+		Name:           this.Settings.Name,
+		Implicit:       implicit,
+		CachedPackages: this.Cache.Len(),
+		Requests:       this.Requests,
+		LastUsed:       this.LastUsed,
+	}
+}
+
+func IsPathInside(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
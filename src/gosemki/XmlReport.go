@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const CHECKSTYLE_VERSION = "8.0"
+
+// Checkstyle XML, understood by Jenkins warnings plugins
+type CheckstyleReport struct {
+	XMLName xml.Name         `xml:"checkstyle"`
+	Version string           `xml:"version,attr"`
+	Files   []CheckstyleFile `xml:"file"`
+}
+
+type CheckstyleFile struct {
+	Name   string            `xml:"name,attr"`
+	Errors []CheckstyleError `xml:"error"`
+}
+
+type CheckstyleError struct {
+	Line     int    `xml:"line,attr"`
+	Column   int    `xml:"column,attr"`
+	Severity string `xml:"severity,attr"`
+	Message  string `xml:"message,attr"`
+	Source   string `xml:"source,attr"`
+}
+
+// JUnit XML: test suite per package directory, test case per file,
+// file with errors is failed test case
+type JunitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []JunitTestSuite `xml:"testsuite"`
+}
+
+type JunitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []JunitTestCase `xml:"testcase"`
+}
+
+type JunitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *JunitFailure `xml:"failure,omitempty"`
+}
+
+type JunitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func FormatCheckstyleErrors(writer io.Writer, report *ErrorReport) {
+	checkstyle := CheckstyleReport{Version: CHECKSTYLE_VERSION}
+	for _, path := range report.Files {
+		file := CheckstyleFile{Name: path}
+		for _, fileError := range GetFileErrors(report, path) {
+			file.Errors = append(file.Errors, CheckstyleError{
+				Line:     fileError.Line,
+				Column:   fileError.Column,
+				Severity: "error",
+				Message:  fileError.Message,
+				Source:   "gosemki." + fileError.Code,
+			})
+		}
+		checkstyle.Files = append(checkstyle.Files, file)
+	}
+	WriteXml(writer, &checkstyle)
+}
+
+func FormatJunitErrors(writer io.Writer, report *ErrorReport) {
+	suites := JunitTestSuites{Name: "gosemki"}
+	suiteIndex := make(map[string]int)
+	for _, path := range report.Files {
+		dir := filepath.ToSlash(filepath.Dir(path))
+		index, ok := suiteIndex[dir]
+		if !ok {
+			index = len(suites.Suites)
+			suiteIndex[dir] = index
+			suites.Suites = append(suites.Suites, JunitTestSuite{Name: dir})
+		}
+		suite := &suites.Suites[index]
+		testCase := JunitTestCase{Name: filepath.Base(path), ClassName: dir}
+		if fileErrors := GetFileErrors(report, path); len(fileErrors) != 0 {
+			var text strings.Builder
+			for _, fileError := range fileErrors {
+				fmt.Fprintf(&text, "%s:%d:%d: %s\n", fileError.Path, fileError.Line, fileError.Column, fileError.Message)
+			}
+			testCase.Failure = &JunitFailure{
+				Message: fmt.Sprintf("%d error(s), first: %s", len(fileErrors), GetSingleLineMessage(fileErrors[0].Message)),
+				Type:    fileErrors[0].Code,
+				Text:    text.String(),
+			}
+			suite.Failures++
+			suites.Failures++
+		}
+		suite.Tests++
+		suites.Tests++
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	WriteXml(writer, &suites)
+}
+
+// Errors of single file, report errors are sorted by path
+func GetFileErrors(report *ErrorReport, path string) []FileError {
+	var fileErrors []FileError
+	for _, fileError := range report.Errors {
+		if fileError.Path == path {
+			fileErrors = append(fileErrors, fileError)
+		}
+	}
+	return fileErrors
+}
+
+func WriteXml(writer io.Writer, value interface{}) {
+	io.WriteString(writer, xml.Header)
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		panic(err)
+	}
+	io.WriteString(writer, "\n")
+}
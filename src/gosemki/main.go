@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
+// Reported in SARIF and other reports consumed by tools
+const GOSEMKI_VERSION = "0.2.0"
+
 func ShowApplicationUsage() {
 	fmt.Fprintf(os.Stderr,
-		"Usage: %s [-s] [-in=<path>]\n"+
+		"Usage: %s [-s] [-in=<path>] [-listen=<address>] [-addr=<address>] [-token=<path>]\n"+
 			"       <command> [<args>]\n\n",
 		os.Args[0])
 	fmt.Fprintf(os.Stderr,
@@ -17,15 +21,59 @@ func ShowApplicationUsage() {
 	flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr,
 		"\nCommands:\n"+
-			"  highlight [<path>]       highlight command\n"+
+			"  highlight [<path>]       highlight command, accepts -from=<offset> and\n"+
+			"                           -to=<offset> to index only visible part of file\n"+
+			"                           -sections=ranges,outline,errors,folds to fetch\n"+
+			"                           only some sections, -stream to print chunks,\n"+
+			"                           -encoding=bytes|utf-16|runes for columns\n"+
+			"  batch <path>...          highlight several files in one request\n"+
+			"  errors <path>            print errors of file package or of directory\n"+
+			"                           packages ('dir/...' for tree),\n"+
+			"                           -format=quickfix|gcc|emacs|sarif|checkstyle|junit\n"+
+			"  outline <path>           print outline tree of file, -json for JSON\n"+
+			"  definition <path>        print position of declaration of identifier at\n"+
+			"                           -offset=<offset>, -json for JSON\n"+
+			"  references <path>        print uses of identifier at -offset=<offset> in\n"+
+			"                           package and workspace, -decl to include\n"+
+			"                           declaration, -json for JSON\n"+
+			"  hover <path>             print signature and doc of identifier at\n"+
+			"                           -offset=<offset>, -json for JSON with type,\n"+
+			"                           markdown doc and value of constant\n"+
+			"  complete <path>          print completion candidates at -offset=<offset>\n"+
+			"                           with kind and type, -json for JSON\n"+
+			"  html <path>              render highlighted file to standalone HTML,\n"+
+			"                           accepts -css=<path>, -css-url=<url>, -title\n"+
+			"  cat <path>               print file with ANSI colors and annotated errors,\n"+
+			"                           accepts -theme=<path>, -colors=256|truecolor, -n\n"+
 			"  close                    close the gocode daemon\n"+
-			"  status                   gocode daemon status report\n")
+			"  status                   gocode daemon status report\n"+
+			"  workspace add <root>     register workspace with current build context,\n"+
+			"                           accepts -name=<name> and -max-packages=<count>\n"+
+			"  workspace remove <root>  unregister workspace and drop its caches\n"+
+			"  lsp                      serve Language Server Protocol on stdin/stdout\n"+
+			"  session                  serve newline-delimited JSON requests on stdin/stdout\n"+
+			"  schema                   print JSON Schema of highlight result\n"+
+			"  crashes                  list saved daemon crash reports\n"+
+			"  crashes show <id>        print crash report details\n"+
+			"  crashes replay <id>      replay crash report in fresh in-process indexer\n")
+	fmt.Fprintf(os.Stderr,
+		"\nAddresses:\n"+
+			"  unix:///path/to/socket   unix socket (default is per-user socket)\n"+
+			"  tcp://127.0.0.1:7890     TCP socket, requires shared secret token file\n"+
+			"\nDaemon also accepts JSON-RPC 2.0 on -jsonrpc=<address>, by default on\n"+
+			"jsonrpc.sock next to default unix socket.\n")
 }
 
 type Application struct {
-	IsServer bool
-	Input    string
-	Server   *Server
+	IsServer        bool
+	Input           string
+	Listen          string
+	Addr            string
+	JsonRpc         string
+	Http            string
+	TokenFile       string
+	ShutdownTimeout time.Duration
+	Server          *Server
 }
 
 var g_app *Application
@@ -33,6 +81,12 @@ var g_app *Application
 func (this *Application) Init() {
 	flag.BoolVar(&this.IsServer, "s", false, "run a server instead of a client")
 	flag.StringVar(&this.Input, "in", "", "use this file instead of stdin input")
+	flag.StringVar(&this.Listen, "listen", "", "server address to listen on instead of default unix socket")
+	flag.StringVar(&this.Addr, "addr", "", "server address to connect instead of default unix socket")
+	flag.StringVar(&this.JsonRpc, "jsonrpc", "", "server address to accept JSON-RPC 2.0 connections, default is next to default unix socket")
+	flag.StringVar(&this.Http, "http", "", "TCP address to serve HTTP/JSON endpoints, e.g. tcp://127.0.0.1:8080")
+	flag.StringVar(&this.TokenFile, "token", "", "shared secret token file used to authenticate TCP connections")
+	flag.DurationVar(&this.ShutdownTimeout, "shutdown-timeout", 5*time.Second, "time given to in-flight requests when daemon closes")
 	flag.Usage = ShowApplicationUsage
 	flag.Parse()
 }
@@ -48,29 +102,89 @@ func (this *Application) Exec() {
 }
 
 func (this *Application) ExecServer() int {
-	this.Server = new(Server)
-	return this.Server.Exec(this.GetSocketFilename())
+	listen := this.Listen
+	if len(listen) == 0 {
+		listen = this.GetSocketFilename()
+	}
+	network, address, err := ParseAddress(listen)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+	jsonRpc := this.JsonRpc
+	if network == "unix" && address == this.GetSocketFilename() {
+		if err = PrepareSocketDir(GetSocketDir()); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return 1
+		}
+		if len(jsonRpc) == 0 {
+			jsonRpc = this.GetJsonRpcSocketFilename()
+		}
+	}
+	this.Server = NewServer()
+	if len(this.Http) != 0 {
+		this.Server.HttpNetwork, this.Server.HttpAddress, err = ParseAddress(this.Http)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return 1
+		}
+	}
+	if len(jsonRpc) != 0 {
+		this.Server.JsonRpcNetwork, this.Server.JsonRpcAddress, err = ParseAddress(jsonRpc)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return 1
+		}
+	}
+	this.Server.TokenFile = this.TokenFile
+	this.Server.ShutdownTimeout = this.ShutdownTimeout
+	return this.Server.Exec(network, address)
 }
 
 func (this *Application) ExecClient() int {
 	if flag.NArg() > 0 {
+		addr := this.Addr
+		if len(addr) == 0 {
+			addr = this.GetSocketFilename()
+		}
+		network, address, err := ParseAddress(addr)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return 1
+		}
 		client := new(Client)
 		client.Input = this.Input
 		client.Command = flag.Arg(0)
 		client.CommandArgs = flag.Args()[1:]
-		client.Socket = this.GetSocketFilename()
+		client.Network = network
+		client.Address = address
+		client.TokenFile = this.TokenFile
+		// Daemon can be started on demand only for discovered local socket,
+		// explicit address usually points to daemon in container or VM
+		client.CanRunServer = len(this.Addr) == 0
 		return client.Exec()
 	}
 	ShowApplicationUsage()
 	return 0
 }
 
+// Socket placed into per-user directory: $XDG_RUNTIME_DIR is private by
+// design, temporary dir is shared so subdirectory name includes user id.
 func (_ *Application) GetSocketFilename() string {
-	user := os.Getenv("USER")
-	if len(user) == 0 {
-		user = "all"
+	return filepath.Join(GetSocketDir(), "daemon.sock")
+}
+
+// JSON-RPC 2.0 socket for plugins written in other languages
+func (_ *Application) GetJsonRpcSocketFilename() string {
+	return filepath.Join(GetSocketDir(), "jsonrpc.sock")
+}
+
+func GetSocketDir() string {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if len(runtimeDir) != 0 {
+		return filepath.Join(runtimeDir, "gosemki")
 	}
-	return filepath.Join(os.TempDir(), fmt.Sprintf("gosemki-daemon.%s", user))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("gosemki-%s", GetUserId()))
 }
 
 func main() {
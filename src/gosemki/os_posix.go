@@ -3,12 +3,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"sync"
+	"syscall"
 )
 
 func FileExists(filename string) bool {
@@ -64,3 +67,30 @@ func PrintBacktrace(err interface{}) {
 	}
 	fmt.Fprintln(os.Stderr, "")
 }
+
+// Unique identifier of the current user, safe to be used in file names
+func GetUserId() string {
+	return strconv.Itoa(os.Getuid())
+}
+
+// Creates directory for daemon socket or checks that existing directory
+// belongs to the current user and is not accessible by others
+func PrepareSocketDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(fmt.Sprintf("socket dir '%s' is not a directory", dir))
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return errors.New(fmt.Sprintf("socket dir '%s' is owned by another user", dir))
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return errors.New(fmt.Sprintf("socket dir '%s' is accessible by other users, expected 0700 permissions", dir))
+	}
+	return nil
+}
@@ -61,3 +61,18 @@ func PrintBacktrace(err interface{}) {
 	}
 	fmt.Fprintln(os.Stderr, "")
 }
+
+// Unique identifier of the current user, safe to be used in file names
+func GetUserId() string {
+	user := os.Getenv("USERNAME")
+	if len(user) == 0 {
+		user = "all"
+	}
+	return user
+}
+
+// Creates directory for daemon socket, access rights are inherited
+// from per-user temporary dir
+func PrepareSocketDir(dir string) error {
+	return os.MkdirAll(dir, 0700)
+}